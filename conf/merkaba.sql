@@ -0,0 +1,125 @@
+-- 脚本的执行记录
+create table if not exists merkaba_run
+(
+    id            varchar(32)  not null primary key,
+    taskName      varchar(255) not null,
+    siteName      varchar(128) not null default '',
+    scriptId      varchar(64)  not null default '',
+    scriptUri     varchar(255) not null default '',
+    scriptVersion varchar(64)  not null default '',
+    runMode       int          not null default 1,
+    parameters    text,
+    status        varchar(16)  not null,
+    error         text,
+    snapshot      varchar(512) not null default '',
+    ip            varchar(64)  not null,
+    server        varchar(128) not null default '',
+    queuedTime    bigint       not null default 0,
+    startTime     bigint       not null default 0,
+    stopTime      bigint       not null default 0,
+    index idx_run_uri (scriptUri, startTime),
+    index idx_run_task (taskName, startTime),
+    index idx_run_status (status, startTime)
+);
+
+-- 节点上的定时任务
+create table if not exists merkaba_schedule
+(
+    id            varchar(32)  not null primary key,
+    ip            varchar(64)  not null,
+    name          varchar(255) not null default '',
+    cron          varchar(128) not null,
+    timezone      varchar(64)  not null default '',
+    scriptId      varchar(64)  not null default '',
+    scriptUri     varchar(255) not null,
+    taskName      varchar(255) not null,
+    parameters    text,
+    overlap       varchar(16)  not null default 'skip',
+    enabled       tinyint      not null default 1,
+    createdTime   bigint       not null default 0,
+    updatedTime   bigint       not null default 0,
+    lastFireTime  bigint       not null default 0,
+    lastRunId     varchar(32)  not null default '',
+    lastStatus    varchar(16)  not null default '',
+    lastError     text,
+    index idx_schedule_ip (ip)
+);
+
+-- 添加字段,字段已经存在时跳过,脚本可以重复执行
+drop procedure if exists merkaba_add_column;
+delimiter //
+create procedure merkaba_add_column(in tableName varchar(64), in columnName varchar(64), in definition varchar(255))
+begin
+    if not exists(select 1 from information_schema.columns
+                  where table_schema = database() and table_name = tableName and column_name = columnName) then
+        set @ddl = concat('alter table `', tableName, '` add column `', columnName, '` ', definition);
+        prepare stmt from @ddl;
+        execute stmt;
+        deallocate prepare stmt;
+    end if;
+end //
+delimiter ;
+
+-- 节点的执行队列限制,0表示使用server.yaml的配置
+call merkaba_add_column('merkaba_node', 'siteLimit', 'int not null default 0');
+call merkaba_add_column('merkaba_node', 'accountLimit', 'int not null default 0');
+
+-- 脚本的重试策略(json),例如 {"maxAttempts":3,"backoff":1000,"retryOn":["timeout","browser"]}
+call merkaba_add_column('merkaba', 'retryPolicy', 'text');
+
+-- 脚本的最长运行时间(秒),超过后按Timeout终止,0表示不限制
+call merkaba_add_column('merkaba', 'maxRunTime', 'int not null default 0');
+
+-- 有重试策略时每次尝试的记录
+create table if not exists merkaba_run_attempt
+(
+    runId         varchar(32)  not null,
+    attempt       int          not null,
+    status        varchar(16)  not null,
+    errorClass    varchar(16)  not null default '',
+    error         text,
+    snapshot      varchar(512) not null default '',
+    startTime     bigint       not null default 0,
+    stopTime      bigint       not null default 0,
+    primary key (runId, attempt)
+);
+
+-- 执行时使用的发布渠道(dev,prd,canary),指定版本执行时为空
+call merkaba_add_column('merkaba_run', 'channel', 'varchar(16) not null default \'\' after scriptVersion');
+
+-- 发布过的脚本版本,按版本执行和回滚时读取
+create table if not exists merkaba_script_version
+(
+    scriptId      varchar(64)  not null,
+    version       varchar(64)  not null,
+    content       mediumtext   not null,
+    createdTime   bigint       not null default 0,
+    primary key (scriptId, version)
+);
+
+-- 正式版本的启用记录,回滚时按启用的顺序回退,rolledBack=1的记录已经被回滚
+create table if not exists merkaba_script_release
+(
+    id            bigint       not null auto_increment primary key,
+    scriptId      varchar(64)  not null,
+    version       varchar(64)  not null,
+    rolledBack    tinyint      not null default 0,
+    createdTime   bigint       not null default 0,
+    key idx_script_release (scriptId, id)
+);
+
+-- 灰度规则,percent%的执行使用候选版本
+create table if not exists merkaba_script_canary
+(
+    scriptId      varchar(64)  not null primary key,
+    version       varchar(64)  not null,
+    percent       int          not null default 0,
+    createdTime   bigint       not null default 0
+);
+
+-- 节点的心跳和负载,heartbeatTime超过server.yaml的node.expire没有更新时节点标记为下线(state=5)
+call merkaba_add_column('merkaba_node', 'cpu', 'double not null default 0');
+call merkaba_add_column('merkaba_node', 'memory', 'double not null default 0');
+call merkaba_add_column('merkaba_node', 'chromeCount', 'int not null default 0');
+call merkaba_add_column('merkaba_node', 'freeVncPorts', 'int not null default 0');
+call merkaba_add_column('merkaba_node', 'heartbeatTime', 'bigint not null default 0');
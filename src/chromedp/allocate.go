@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"merkaba/common/metrics"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -213,12 +214,14 @@ func (a *ExecAllocator) Allocate(ctx context.Context, opts ...BrowserOption) (*B
 	if a.combinedOutputWriter != nil {
 		a.wg.Add(1) // for the io.Copy in a separate goroutine
 	}
+	metrics.ChromeProcesses.Inc()
 	go func() {
 		// First wait for the process to be finished.
 		// TODO: do we care about this error in any scenario? if the
 		// user cancelled the context and killed chrome, this will most
 		// likely just be "signal: killed", which isn't interesting.
 		cmd.Wait()
+		metrics.ChromeProcesses.Dec()
 
 		// Then delete the temporary user data directory, if needed.
 		if removeDir {
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"merkaba/common"
+	"merkaba/common/metrics"
 	"strconv"
 	"strings"
 	"sync"
@@ -213,6 +214,7 @@ func (s *Selector) Do(ctx context.Context) error {
 			/*每个sel如果超过过期时间还没有出现，且没有注册处理器，操作将全部结束，否则以处理器返回结果为准*/
 			if s.MaxWaitTime > 0 && t >= s.MaxWaitTime*1000 {
 				fromTime = time.Now().UnixMilli()
+				metrics.SelectorTimeouts.WithLabelValues("wait").Inc()
 				if s.ScriptHandler != nil {
 					ok, err = s.ScriptHandler.HandleTimeout(s.WebPage, err)
 					return ok, err
@@ -221,6 +223,7 @@ func (s *Selector) Do(ctx context.Context) error {
 				}
 			} else {
 				if actionTimeout {
+					metrics.SelectorTimeouts.WithLabelValues("action").Inc()
 					return true, ErrActionTimeout
 				} else {
 					return false, nil
@@ -231,6 +234,7 @@ func (s *Selector) Do(ctx context.Context) error {
 		// if nodes==nil, we're not yet ready
 		if nodes == nil || err != nil {
 			if actionTimeout {
+				metrics.SelectorTimeouts.WithLabelValues("action").Inc()
 				return true, ErrActionTimeout
 			} else {
 				return false, nil
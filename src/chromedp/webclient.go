@@ -101,9 +101,6 @@ func (client *WebClient) Load(pageId string, url string) *WebPage {
 }
 
 func (client *WebClient) sendLog(level string, msg string, fields ...zap.Field) {
-	if client.Context.RunMode != common.RunModeBrowserRun {
-		return
-	}
 	log := make(map[string]any)
 	log["message"] = msg
 	data := make(map[string]any)
@@ -111,6 +108,11 @@ func (client *WebClient) sendLog(level string, msg string, fields ...zap.Field)
 	for _, field := range fields {
 		log[field.Key] = field.Interface
 	}
+	/*只有浏览器调试模式才发送到pulsar*/
+	if client.Context.RunMode != common.RunModeBrowserRun {
+		common.PublishLog(level, client.Context, log)
+		return
+	}
 	common.SendLog(level, client.Context, log)
 }
 
@@ -238,19 +240,27 @@ func (client *WebClient) Ocr(image string) (resp map[string]any, err error) {
 	return resp, err
 }
 
+// CurrentPage 当前激活的页面,没有页面时返回nil
+func (client *WebClient) CurrentPage() *WebPage {
+	if client.CurrentPageIndex < 0 || client.CurrentPageIndex >= len(client.Pages) {
+		return nil
+	}
+	return client.Pages[client.CurrentPageIndex]
+}
+
 func (client *WebClient) RefreshVNC() {
-	if client.CurrentPageIndex >= len(client.Pages) {
+	page := client.CurrentPage()
+	if page == nil {
 		return
 	}
-	page := client.Pages[client.CurrentPageIndex]
 	page.RefreshVNC()
 }
 
 func (client *WebClient) Snapshot(fileName string) {
-	if client.CurrentPageIndex >= len(client.Pages) {
+	page := client.CurrentPage()
+	if page == nil {
 		return
 	}
-	page := client.Pages[client.CurrentPageIndex]
 	bytes, err := page.WriteScreenShot()
 	if err != nil {
 		return
@@ -18,7 +18,9 @@ import (
 	"merkaba/chromedp/cdproto/target"
 	"merkaba/common"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -31,6 +33,8 @@ type WebPage struct {
 	loggerStd  *zap.Logger
 	loggerTask *zap.Logger
 	cancel     func()
+	castLock   sync.Mutex
+	cast       *screencast
 }
 
 func NewPage(id string, client *WebClient) *WebPage {
@@ -96,6 +100,14 @@ func (p *WebPage) Logger() *zap.Logger {
 	}
 }
 
+/*页面操作之前检查暂停,暂停时等待恢复*/
+func (p *WebPage) waitResume() {
+	if p.runCtx.Pause.Paused() {
+		p.info("paused")
+		p.runCtx.Pause.Wait()
+	}
+}
+
 func (p *WebPage) info(msg string, fields ...zap.Field) {
 	p.Logger().Info(msg, fields...)
 }
@@ -110,6 +122,7 @@ func (p *WebPage) parseQueryOption(sel interface{}, all bool) QueryOption {
 }
 
 func (p *WebPage) Load(url string) (err error) {
+	p.waitResume()
 	p.Url = url
 	p.registerListener(p.Ctx)
 	err = Run(p.Ctx, p.loadCookies(), Navigate(url),
@@ -310,6 +323,7 @@ func (p *WebPage) Value(sel interface{}, value *string) (err error) {
 }
 
 func (p *WebPage) SetValue(sel interface{}, value string) (err error) {
+	p.waitResume()
 	p.info("setValue", zap.String("sel", sel.(string)), zap.String("value", value))
 	err = Run(p.Ctx,
 		SetValue(sel, value, p.Client.parseQueryOption(sel, false), SetRunParam(p.scriptHandler(), p, p.maxWaitTime())),
@@ -398,6 +412,7 @@ func (p *WebPage) WriteScreenShot() (*[]byte, error) {
 }
 
 func (p *WebPage) SendKeys(sel interface{}, value string) (err error) {
+	p.waitResume()
 	p.info("sendKeys", zap.String("sel", sel.(string)), zap.String("value", value))
 	err = Run(p.Ctx,
 		SendKeys(sel, value, p.Client.parseQueryOption(sel, false), SetScrollIntoView(), SetRunParam(p.scriptHandler(), p, p.maxWaitTime())),
@@ -412,6 +427,7 @@ func (p *WebPage) SendKeys(sel interface{}, value string) (err error) {
 }
 
 func (p *WebPage) ClickDown(sel interface{}, maxSecond int64) (fileName string, err error) {
+	p.waitResume()
 	p.info("Click " + sel.(string) + ",start download file")
 	done := make(chan string, 1)
 	ListenTarget(p.Ctx, func(v interface{}) {
@@ -425,9 +441,10 @@ func (p *WebPage) ClickDown(sel interface{}, maxSecond int64) (fileName string,
 		}
 	})
 	queryOption := p.Client.parseQueryOption(sel, false)
+	downloadPath := common.ArtifactDir(p.runCtx.RunId)
 	e := Run(p.Ctx,
 		browser.SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllowAndName).
-			WithDownloadPath(common.Env.Path.Temp).
+			WithDownloadPath(downloadPath).
 			WithEventsEnabled(true),
 		Click(sel, queryOption),
 	)
@@ -442,11 +459,13 @@ func (p *WebPage) ClickDown(sel interface{}, maxSecond int64) (fileName string,
 		p.printError(sel.(string), e)
 		return "", e
 	case guid = <-done:
-		return common.Env.Path.Temp + guid, nil
+		common.RecordArtifact(p.runCtx.RunId, common.ArtifactDownload, downloadPath+guid)
+		return downloadPath + guid, nil
 	}
 }
 
 func (p *WebPage) Upload(sel interface{}, fileName string) (err error) {
+	p.waitResume()
 	p.info("upload", zap.String("sel", sel.(string)), zap.String("fileName", fileName))
 	err = Run(p.Ctx,
 		SetUploadFiles(sel, []string{fileName}, p.Client.parseQueryOption(sel, false), SetScrollIntoView(), SetRunParam(p.scriptHandler(), p, p.maxWaitTime())),
@@ -462,10 +481,15 @@ func (p *WebPage) Upload(sel interface{}, fileName string) (err error) {
 
 func (p *WebPage) Wait(v int64) {
 	p.info("upload", zap.Int64("time", v))
-	time.Sleep(time.Duration(v) * time.Millisecond)
+	/*页面关闭或者运行超时时立即返回*/
+	select {
+	case <-time.After(time.Duration(v) * time.Millisecond):
+	case <-p.Ctx.Done():
+	}
 }
 
 func (p *WebPage) Click(sel interface{}, millisecond int64) (err error) {
+	p.waitResume()
 	p.info("Click", zap.String("sel", sel.(string)))
 	queryOption := p.Client.parseQueryOption(sel, false)
 	e := Run(p.Ctx,
@@ -498,6 +522,7 @@ func (p *WebPage) ScrollIntoView(sel interface{}) (err error) {
 }
 
 func (p *WebPage) MouseDrag(sel interface{}, offsetX float64) (err error) {
+	p.waitResume()
 	p.info("MouseDrag", zap.String("sel", sel.(string)), zap.Float64("offsetX", offsetX))
 	err = Run(p.Ctx,
 		MouseDrag(sel, offsetX, p.Client.parseQueryOption(sel, false), SetScrollIntoView(), SetRunParam(p.scriptHandler(), p, p.maxWaitTime())),
@@ -512,6 +537,7 @@ func (p *WebPage) MouseDrag(sel interface{}, offsetX float64) (err error) {
 }
 
 func (p *WebPage) MouseOver(sel interface{}) (err error) {
+	p.waitResume()
 	p.info("mouseOver", zap.String("sel", sel.(string)))
 	err = Run(p.Ctx,
 		MouseOver(sel, p.Client.parseQueryOption(sel, false), SetScrollIntoView(), SetRunParam(p.scriptHandler(), p, p.maxWaitTime())),
@@ -697,8 +723,10 @@ func (p *WebPage) Html() string {
 }
 
 func (p *WebPage) SaveHtml(fileName string) {
-	_fileName := common.RootPath + "tmp/" + fileName
+	_fileName := common.ArtifactDir(p.runCtx.RunId) + filepath.Base(fileName)
 	p.info("save html", zap.String("fileName", _fileName))
 	content := p.Html()
-	os.WriteFile(_fileName, []byte(content), 0644)
+	if err := os.WriteFile(_fileName, []byte(content), 0644); err == nil {
+		common.RecordArtifact(p.runCtx.RunId, common.ArtifactHtml, _fileName)
+	}
 }
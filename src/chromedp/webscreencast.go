@@ -0,0 +1,102 @@
+package chromedp
+
+import (
+	"context"
+	"encoding/base64"
+	"go.uber.org/zap"
+	"merkaba/chromedp/cdproto/page"
+	"sync"
+)
+
+const (
+	ScreencastQuality  = 60
+	ScreencastMaxWidth = 1280
+)
+
+/*页面的录屏,多个订阅者共享一个CDP screencast*/
+type screencast struct {
+	lock        sync.Mutex
+	subscribers map[int]chan []byte
+	nextId      int
+	cancel      func()
+}
+
+// Screencast 订阅页面的jpeg画面帧,返回帧通道和取消订阅的函数
+func (p *WebPage) Screencast() (<-chan []byte, func(), error) {
+	p.castLock.Lock()
+	if p.cast == nil {
+		p.cast = &screencast{subscribers: make(map[int]chan []byte)}
+	}
+	cast := p.cast
+	p.castLock.Unlock()
+
+	cast.lock.Lock()
+	defer cast.lock.Unlock()
+	if len(cast.subscribers) == 0 {
+		if err := p.startScreencast(cast); err != nil {
+			p.printError("startScreencast", err)
+			return nil, nil, err
+		}
+	}
+	id := cast.nextId
+	cast.nextId++
+	frames := make(chan []byte, 2)
+	cast.subscribers[id] = frames
+	stop := func() {
+		cast.lock.Lock()
+		defer cast.lock.Unlock()
+		if _, ok := cast.subscribers[id]; !ok {
+			return
+		}
+		delete(cast.subscribers, id)
+		if len(cast.subscribers) == 0 {
+			p.stopScreencast(cast)
+		}
+	}
+	return frames, stop, nil
+}
+
+func (p *WebPage) startScreencast(cast *screencast) error {
+	listenCtx, cancel := context.WithCancel(p.Ctx)
+	ListenTarget(listenCtx, func(ev interface{}) {
+		frame, ok := ev.(*page.EventScreencastFrame)
+		if !ok {
+			return
+		}
+		/*监听函数里面不能阻塞,确认帧需要在单独的协程里执行*/
+		go Run(p.Ctx, page.ScreencastFrameAck(frame.SessionID))
+		data, err := base64.StdEncoding.DecodeString(frame.Data)
+		if err != nil {
+			return
+		}
+		cast.lock.Lock()
+		for _, frames := range cast.subscribers {
+			select {
+			case frames <- data:
+			default:
+				/*订阅者处理不过来则丢帧*/
+			}
+		}
+		cast.lock.Unlock()
+	})
+	err := Run(p.Ctx, page.StartScreencast().
+		WithFormat(page.ScreencastFormatJpeg).
+		WithQuality(ScreencastQuality).
+		WithMaxWidth(ScreencastMaxWidth))
+	if err != nil {
+		cancel()
+		return err
+	}
+	cast.cancel = cancel
+	p.info("start screencast", zap.String("url", p.Url))
+	return nil
+}
+
+func (p *WebPage) stopScreencast(cast *screencast) {
+	if cast.cancel != nil {
+		cast.cancel()
+		cast.cancel = nil
+	}
+	go Run(p.Ctx, page.StopScreencast())
+	p.info("stop screencast", zap.String("url", p.Url))
+}
@@ -0,0 +1,228 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ArtifactScreenshot = "screenshot"
+	ArtifactDownload   = "download"
+	ArtifactHtml       = "html"
+	ArtifactFile       = "file"
+)
+
+const (
+	/*每个运行目录下的元数据文件*/
+	artifactMetaFile         = "artifacts.json"
+	defaultArtifactRetention = 72 * time.Hour
+)
+
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// Artifact 脚本运行时产生的文件,保存在产物根目录的{runId}/目录下
+type Artifact struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	MimeType    string `json:"mimeType"`
+	Size        int64  `json:"size"`
+	CreatedTime int64  `json:"createdTime"`
+}
+
+/*同一个运行目录的元数据文件串行读写*/
+var artifactLock sync.Mutex
+
+// ArtifactRoot 产物根目录,没有配置时使用temp下的artifacts目录
+func ArtifactRoot() string {
+	root := Env.Artifact.Root
+	if len(root) == 0 {
+		root = Env.Path.Temp + "artifacts/"
+	}
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	return root
+}
+
+func artifactRetention() time.Duration {
+	if Env.Artifact.Retention > 0 {
+		return time.Duration(Env.Artifact.Retention) * time.Hour
+	}
+	return defaultArtifactRetention
+}
+
+/*runId只能作为一级目录名,防止通过../访问其他目录*/
+func validArtifactName(name string) bool {
+	return len(name) > 0 && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+/*产物名称是运行目录下的相对路径,每一级都不能是.或者..*/
+func validArtifactPath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if !validArtifactName(part) {
+			return false
+		}
+	}
+	return true
+}
+
+/*fileName在运行目录下时使用相对路径作为名称,否则只使用文件名*/
+func artifactName(dir string, fileName string) string {
+	rel, err := filepath.Rel(dir, fileName)
+	if err == nil {
+		rel = filepath.ToSlash(rel)
+		if validArtifactPath(rel) {
+			return rel
+		}
+	}
+	return filepath.Base(fileName)
+}
+
+// ArtifactDir 返回本次运行的产物目录(以/结尾),不存在时创建;runId为空时返回temp目录
+func ArtifactDir(runId string) string {
+	if !validArtifactName(runId) {
+		return Env.Path.Temp
+	}
+	dir := ArtifactRoot() + runId + "/"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		LoggerStd.Error("create artifact dir", zap.String("dir", dir), zap.Error(err))
+		return Env.Path.Temp
+	}
+	return dir
+}
+
+// RecordArtifact 记录运行目录下的文件,同名文件覆盖之前的记录
+func RecordArtifact(runId string, kind string, fileName string) {
+	if !validArtifactName(runId) {
+		return
+	}
+	dir := ArtifactRoot() + runId + "/"
+	name := artifactName(dir, fileName)
+	info, err := os.Stat(dir + name)
+	if err != nil || info.IsDir() {
+		LoggerStd.Warn("record artifact", zap.String("runId", runId), zap.String("name", name), zap.Error(err))
+		return
+	}
+	artifact := Artifact{
+		Name:        name,
+		Kind:        kind,
+		MimeType:    detectMimeType(dir + name),
+		Size:        info.Size(),
+		CreatedTime: info.ModTime().UnixMilli(),
+	}
+	artifactLock.Lock()
+	defer artifactLock.Unlock()
+	artifacts, _ := readArtifactMeta(dir)
+	replaced := false
+	for i := range artifacts {
+		if artifacts[i].Name == name {
+			artifacts[i] = artifact
+			replaced = true
+		}
+	}
+	if !replaced {
+		artifacts = append(artifacts, artifact)
+	}
+	data, _ := json.Marshal(artifacts)
+	if err := os.WriteFile(dir+artifactMetaFile, data, 0644); err != nil {
+		LoggerStd.Error("write artifact meta", zap.String("runId", runId), zap.Error(err))
+	}
+}
+
+func readArtifactMeta(dir string) ([]Artifact, error) {
+	data, err := os.ReadFile(dir + artifactMetaFile)
+	if err != nil {
+		return nil, err
+	}
+	var artifacts []Artifact
+	err = json.Unmarshal(data, &artifacts)
+	return artifacts, err
+}
+
+// ListArtifacts 按创建时间返回本次运行的产物,运行目录不存在时返回ErrArtifactNotFound
+func ListArtifacts(runId string) ([]Artifact, error) {
+	if !validArtifactName(runId) {
+		return nil, ErrArtifactNotFound
+	}
+	dir := ArtifactRoot() + runId + "/"
+	if !CheckFileExist(dir) {
+		return nil, ErrArtifactNotFound
+	}
+	artifactLock.Lock()
+	artifacts, err := readArtifactMeta(dir)
+	artifactLock.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sort.SliceStable(artifacts, func(i, j int) bool {
+		return artifacts[i].CreatedTime < artifacts[j].CreatedTime
+	})
+	return artifacts, nil
+}
+
+// FindArtifact 返回已记录产物的元数据和文件路径
+func FindArtifact(runId string, name string) (*Artifact, string, error) {
+	if !validArtifactPath(name) {
+		return nil, "", ErrArtifactNotFound
+	}
+	artifacts, err := ListArtifacts(runId)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range artifacts {
+		if artifacts[i].Name == name {
+			return &artifacts[i], ArtifactRoot() + runId + "/" + name, nil
+		}
+	}
+	return nil, "", ErrArtifactNotFound
+}
+
+// CleanArtifacts 删除超过保留时间的运行目录,返回删除的runId
+func CleanArtifacts() []string {
+	root := ArtifactRoot()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	expired := time.Now().Add(-artifactRetention())
+	removed := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(expired) {
+			continue
+		}
+		if err := os.RemoveAll(root + entry.Name()); err != nil {
+			LoggerStd.Error("remove artifacts", zap.String("runId", entry.Name()), zap.Error(err))
+			continue
+		}
+		removed = append(removed, entry.Name())
+	}
+	return removed
+}
+
+/*优先按扩展名判断,下载的文件没有扩展名时读取文件头*/
+func detectMimeType(fileName string) string {
+	if t := mime.TypeByExtension(filepath.Ext(fileName)); len(t) > 0 {
+		return t
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := f.Read(head)
+	return http.DetectContentType(head[:n])
+}
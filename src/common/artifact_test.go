@@ -0,0 +1,44 @@
+package common
+
+import (
+	"go.uber.org/zap"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestArtifact(t *testing.T) {
+	LoggerStd = zap.NewNop()
+	Env = &YamlFile{}
+	Env.Artifact.Root = t.TempDir()
+	Env.Artifact.Retention = 1
+	dir := ArtifactDir("run1")
+	os.WriteFile(dir+"page.html", []byte("<html></html>"), 0644)
+	RecordArtifact("run1", ArtifactHtml, dir+"page.html")
+	RecordArtifact("run1", ArtifactHtml, dir+"page.html")
+	artifacts, err := ListArtifacts("run1")
+	if err != nil || len(artifacts) != 1 {
+		t.Fatalf("list artifacts %v %v", artifacts, err)
+	}
+	if artifacts[0].MimeType != "text/html; charset=utf-8" || artifacts[0].Size != 13 {
+		t.Fatalf("artifact meta %+v", artifacts[0])
+	}
+	if _, _, err := FindArtifact("run1", "../run1/page.html"); err != ErrArtifactNotFound {
+		t.Fatalf("find with path %v", err)
+	}
+	if _, err := ListArtifacts(".."); err != ErrArtifactNotFound {
+		t.Fatalf("list parent %v", err)
+	}
+	/*子目录下的文件按相对路径记录*/
+	os.MkdirAll(dir+"reports", 0755)
+	os.WriteFile(dir+"reports/a.csv", []byte("a,b"), 0644)
+	RecordArtifact("run1", ArtifactFile, dir+"reports/a.csv")
+	if artifact, fileName, err := FindArtifact("run1", "reports/a.csv"); err != nil || artifact.Size != 3 || fileName != dir+"reports/a.csv" {
+		t.Fatalf("find in sub dir %v %s %v", artifact, fileName, err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(dir, old, old)
+	if removed := CleanArtifacts(); len(removed) != 1 || removed[0] != "run1" {
+		t.Fatalf("clean artifacts %v", removed)
+	}
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	StateQueued  = "queued"
+	StateStarted = "started"
+	StatePaused  = "paused"
+	StateResumed = "resumed"
+	StateStopped = "stopped"
+	StateError   = "error"
+	StateTimeout = "timeout"
+)
+
+// Event 推送给订阅者的事件,格式和发送到pulsar的消息相同
+type Event = map[string]any
+
+// Broadcaster 进程内的事件广播,订阅者按taskName过滤
+type Broadcaster struct {
+	lock        sync.RWMutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+var Events = NewBroadcaster()
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subscribers: make(map[string]map[chan Event]struct{})}
+}
+
+// Subscribe 订阅任务的事件,taskName为空时订阅所有任务,返回事件通道和取消订阅的函数
+func (b *Broadcaster) Subscribe(taskName string) (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	b.lock.Lock()
+	set := b.subscribers[taskName]
+	if set == nil {
+		set = make(map[chan Event]struct{})
+		b.subscribers[taskName] = set
+	}
+	set[ch] = struct{}{}
+	b.lock.Unlock()
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subscribers[taskName], ch)
+			if len(b.subscribers[taskName]) == 0 {
+				delete(b.subscribers, taskName)
+			}
+			b.lock.Unlock()
+		})
+	}
+	return ch, cancel
+}
+
+// Publish 发布事件,订阅者处理不过来时丢弃,不阻塞脚本的运行
+func (b *Broadcaster) Publish(taskName string, event Event) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for _, key := range []string{taskName, ""} {
+		for ch := range b.subscribers[key] {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+		if len(taskName) == 0 {
+			break
+		}
+	}
+}
+
+// PublishState 发布实例的生命周期变化(queued,started,stopped,error)
+func PublishState(ctx *RunContext, state string, message string) {
+	info := make(map[string]any)
+	info["type"] = "state"
+	info["state"] = state
+	info["server"] = LocalName
+	info["ip"] = LocalIP
+	info["cookieId"] = ctx.CookieId
+	info["taskName"] = ctx.TaskName
+	info["runId"] = ctx.RunId
+	info["scriptUri"] = ctx.ScriptUri
+	info["scriptVersion"] = ctx.ScriptVersion
+	info["message"] = message
+	info["timestamp"] = time.Now().UnixMilli()
+	Events.Publish(ctx.TaskName, info)
+}
@@ -0,0 +1,25 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestBroadcaster(t *testing.T) {
+	b := NewBroadcaster()
+	task, cancelTask := b.Subscribe("demo")
+	all, cancelAll := b.Subscribe("")
+	defer cancelAll()
+	b.Publish("demo", Event{"type": "message"})
+	b.Publish("other", Event{"type": "message"})
+	if len(task) != 1 {
+		t.Fatalf("task subscriber got %d events", len(task))
+	}
+	if len(all) != 2 {
+		t.Fatalf("all subscriber got %d events", len(all))
+	}
+	cancelTask()
+	b.Publish("demo", Event{"type": "message"})
+	if len(task) != 1 {
+		t.Fatalf("canceled subscriber got %d events", len(task))
+	}
+}
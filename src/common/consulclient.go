@@ -67,6 +67,21 @@ func (c *ConsulClient) ReadDbConfig(name string) *SqlDBConfig {
 	return &result
 }
 
+// ReadAuthTokens 读取consul中配置的接口凭证,key不存在时返回nil
+func (c *ConsulClient) ReadAuthTokens() []AuthToken {
+	data := c.readConsulConfig("merkaba", "auth")
+	if data == nil {
+		return nil
+	}
+	var result []AuthToken
+	err := json.Unmarshal(data.Value, &result)
+	if err != nil {
+		LoggerStd.Error("consul read auth", zap.NamedError("error", err))
+		return nil
+	}
+	return result
+}
+
 func (c *ConsulClient) RegisterMerkaba() {
 	LoggerStd.Info("Register merkaba node ", zap.String("localIP", LocalIP), zap.Int("localPort", LocalPort))
 	registration := new(api.AgentServiceRegistration)
@@ -85,6 +100,15 @@ func (c *ConsulClient) RegisterMerkaba() {
 	c.instance.Agent().ServiceRegister(registration)
 }
 
+// DeregisterMerkaba 从consul注销节点,调用方不会再把任务分配到本节点
+func (c *ConsulClient) DeregisterMerkaba() {
+	LoggerStd.Info("Deregister merkaba node ", zap.String("localIP", LocalIP), zap.Int("localPort", LocalPort))
+	err := c.instance.Agent().ServiceDeregister(LocalIP)
+	if err != nil {
+		LoggerStd.Error("consul deregister", zap.NamedError("error", err))
+	}
+}
+
 func (c *ConsulClient) NextPlatoServer() string {
 	catalogServices, _, _ := c.instance.Catalog().Service("plato", "", nil)
 	size := len(catalogServices)
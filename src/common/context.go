@@ -9,14 +9,17 @@ type RunContext struct {
 	CookieId      string
 	SiteName      string
 	TaskName      string /*实例的名称*/
+	RunId         string /*本次执行的id*/
 	ScriptId      string
 	ScriptUri     string
 	ScriptVersion string
+	ScriptChannel string /*dev,prd,canary,指定版本时为空*/
 	MaxWaitTime   int64
 	Headless      bool
 	Proxy         bool
 	OnClientClose func(ev interface{})
 	Parameters    map[string]interface{}
+	Pause         PauseGate
 	loggerTask    *zap.Logger
 }
 
@@ -53,6 +56,7 @@ func (ctx *RunContext) AsMap() map[string]any {
 	data["siteName"] = ctx.SiteName
 	data["scriptId"] = ctx.ScriptId
 	data["taskName"] = ctx.TaskName
+	data["runId"] = ctx.RunId
 	data["scriptUri"] = ctx.ScriptUri
 	data["scriptVersion"] = ctx.ScriptVersion
 	return data
@@ -151,6 +151,12 @@ func InitEnviroment() {
 	} else {
 		LoggerStd.Info("Develop Mode", zap.String("ip", Env.Consul.Development[0]))
 	}
+	if Env.Vnc.PortFrom > 0 {
+		VncPortFrom = Env.Vnc.PortFrom
+	}
+	if Env.Vnc.Ports > 0 {
+		VncPortCount = Env.Vnc.Ports
+	}
 	if len(Env.Environment.LocalIP) == 0 {
 		LocalIP, _ = getClientIp()
 	} else {
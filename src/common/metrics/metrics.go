@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"time"
+)
+
+const namespace = "merkaba"
+
+var (
+	ScriptRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "script_runs_total",
+		Help:      "Number of finished script runs.",
+	}, []string{"scriptUri", "status"})
+
+	ScriptRunDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "script_run_duration_seconds",
+		Help:      "Wall time of finished script runs.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"scriptUri", "status"})
+
+	SelectorTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "selector_timeouts_total",
+		Help:      "Selectors that did not appear in time, by kind (wait, action).",
+	}, []string{"kind"})
+
+	HandleTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handle_timeout_total",
+		Help:      "Invocations of the script handleTimeout function.",
+	}, []string{"scriptUri"})
+
+	ChromeProcesses = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "chrome_processes",
+		Help:      "Live chrome processes started by the node.",
+	})
+
+	VncSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "vnc_sessions",
+		Help:      "Open VNC sessions.",
+	})
+
+	PulsarSendFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pulsar_send_failures_total",
+		Help:      "Messages that failed to be sent to pulsar.",
+	}, []string{"method"})
+
+	GrpcInvokeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_invoke_duration_seconds",
+		Help:      "Latency of grpc calls to the app server.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "method"})
+
+	Evictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "instance_evictions_total",
+		Help:      "Idle instances evicted with their browser, by reason (idleTTL, maxIdle, memory, oneShot).",
+	}, []string{"reason"})
+
+	ProgramCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "program_cache_total",
+		Help:      "Lookups of compiled scripts and modules, by result (hit, miss, evict).",
+	}, []string{"result"})
+
+	GrpcInvokeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_invoke_errors_total",
+		Help:      "Failed grpc calls to the app server.",
+	}, []string{"service", "method"})
+)
+
+// QueueStats 执行队列的统计,由queue.Queue实现
+type QueueStats interface {
+	Pending() int
+	Busy() int
+}
+
+// WatchQueue 注册执行队列的指标,采集时读取队列的当前值
+func WatchQueue(q QueueStats) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_pending",
+		Help:      "Tasks waiting for a worker.",
+	}, func() float64 { return float64(q.Pending()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_busy_workers",
+		Help:      "Workers running a task.",
+	}, func() float64 { return float64(q.Busy()) })
+}
+
+// GaugeValue 读取gauge的当前值,例如节点心跳上报浏览器进程数
+func GaugeValue(g prometheus.Gauge) float64 {
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		return 0
+	}
+	return m.GetGauge().GetValue()
+}
+
+// ObserveRun 记录一次脚本执行的结果和耗时
+func ObserveRun(scriptUri string, status string, duration time.Duration) {
+	ScriptRuns.WithLabelValues(scriptUri, status).Inc()
+	ScriptRunDuration.WithLabelValues(scriptUri, status).Observe(duration.Seconds())
+}
+
+// ObserveInvoke 记录一次grpc调用的耗时,失败时增加错误计数
+func ObserveInvoke(service string, method string, start time.Time, err error) {
+	GrpcInvokeDuration.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		GrpcInvokeErrors.WithLabelValues(service, method).Inc()
+	}
+}
@@ -0,0 +1,270 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazelcast/hazelcast-go-client/serialization"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+	"go.uber.org/zap"
+	"merkaba/common/metrics"
+	"runtime"
+	"sync"
+	"time"
+)
+
+/*merkaba_node.state*/
+const (
+	NodeStateUp       = 4
+	NodeStateDown     = 5
+	NodeStateDraining = 6
+)
+
+/*server.yaml中node.registry的取值*/
+const (
+	NodeRegistryMysql     = "mysql"
+	NodeRegistryHazelcast = "hazelcast"
+)
+
+const (
+	defaultNodeHeartbeat = 10
+	defaultNodeExpire    = 30
+)
+
+// NodeLoad 节点的状态和负载,每次心跳上报
+type NodeLoad struct {
+	IP            string  `db:"ip" json:"ip"`
+	Name          string  `db:"name" json:"name"`
+	Port          int     `db:"port" json:"port"`
+	Platform      string  `db:"platform" json:"platform"`
+	State         int     `db:"state" json:"state"`
+	RunCount      int     `db:"runCount" json:"runCount"`
+	IdleCount     int     `db:"idleCount" json:"idleCount"`
+	Cpu           float64 `db:"cpu" json:"cpu"`       // cpu使用率(%)
+	Memory        float64 `db:"memory" json:"memory"` // 内存使用率(%)
+	ChromeCount   int     `db:"chromeCount" json:"chromeCount"`
+	FreeVncPorts  int     `db:"freeVncPorts" json:"freeVncPorts"`
+	HeartbeatTime int64   `db:"heartbeatTime" json:"heartbeatTime"`
+}
+
+// ReadNodeLoad 本节点当前的负载,实例数量由调用方统计
+func ReadNodeLoad(state int, runCount int, idleCount int) *NodeLoad {
+	load := &NodeLoad{
+		IP:            LocalIP,
+		Name:          LocalName,
+		Port:          LocalPort,
+		Platform:      runtime.GOOS,
+		State:         state,
+		RunCount:      runCount,
+		IdleCount:     idleCount,
+		ChromeCount:   int(metrics.GaugeValue(metrics.ChromeProcesses)),
+		FreeVncPorts:  FreeVncPorts(),
+		HeartbeatTime: time.Now().UnixMilli(),
+	}
+	/*间隔为0时返回距离上次调用的使用率,不阻塞心跳*/
+	if percent, err := cpu.Percent(0, false); err == nil && len(percent) > 0 {
+		load.Cpu = percent[0]
+	}
+	if v, err := mem.VirtualMemory(); err == nil {
+		load.Memory = v.UsedPercent
+	}
+	return load
+}
+
+// NodeHeartbeat 心跳间隔
+func NodeHeartbeat() time.Duration {
+	if Env != nil && Env.Node.Heartbeat > 0 {
+		return time.Duration(Env.Node.Heartbeat) * time.Second
+	}
+	return defaultNodeHeartbeat * time.Second
+}
+
+// NodeExpire 超过这个时间没有心跳的节点认为已经下线
+func NodeExpire() time.Duration {
+	if Env != nil && Env.Node.Expire > 0 {
+		return time.Duration(Env.Node.Expire) * time.Second
+	}
+	return defaultNodeExpire * time.Second
+}
+
+// NodeRegistry 节点注册,按心跳维护节点的状态和负载
+type NodeRegistry interface {
+	// Register 节点启动时登记
+	Register(load *NodeLoad) error
+	// Heartbeat 更新负载和心跳时间
+	Heartbeat(load *NodeLoad) error
+	// SetState 修改本节点的状态(下线,准备下线)
+	SetState(state int) error
+	// Expire 心跳超时的节点标记为下线,返回标记的数量
+	Expire(ttl time.Duration) (int, error)
+	// Nodes 所有登记的节点
+	Nodes() ([]NodeLoad, error)
+}
+
+// NewNodeRegistry 按server.yaml的node.registry创建,默认使用mysql
+func NewNodeRegistry(env *YamlFile, client *MysqlClient) (NodeRegistry, error) {
+	switch env.Node.Registry {
+	case "", NodeRegistryMysql:
+		return &MysqlNodeRegistry{Client: client}, nil
+	case NodeRegistryHazelcast:
+		if Hazelcast == nil {
+			InitHazelClient()
+		}
+		return &HazelcastNodeRegistry{Client: Hazelcast, TTL: NodeExpire()}, nil
+	default:
+		return nil, fmt.Errorf("unknown node registry %s", env.Node.Registry)
+	}
+}
+
+// MysqlNodeRegistry 节点保存在merkaba_node表,按ip区分
+type MysqlNodeRegistry struct {
+	Client *MysqlClient
+}
+
+func (r *MysqlNodeRegistry) Register(load *NodeLoad) error {
+	sql := `
+            insert into merkaba_node(ip,name,port,platform,createdTime,state,runCount,idleCount,cpu,memory,chromeCount,freeVncPorts,heartbeatTime)
+            values(?,?,?,?,?,?,?,?,?,?,?,?,?)
+            on duplicate key update
+                name = values(name),port = values(port),platform = values(platform),
+                createdTime=values(createdTime),state=values(state),
+                runCount=values(runCount),idleCount=values(idleCount),cpu=values(cpu),memory=values(memory),
+                chromeCount=values(chromeCount),freeVncPorts=values(freeVncPorts),heartbeatTime=values(heartbeatTime)
+	`
+	var _, err = r.Client.Update(sql, load.IP, load.Name, load.Port, load.Platform, time.Now().UnixMilli(), load.State,
+		load.RunCount, load.IdleCount, load.Cpu, load.Memory, load.ChromeCount, load.FreeVncPorts, load.HeartbeatTime)
+	if err != nil {
+		LoggerStd.Error(sql, zap.Error(err))
+	}
+	return err
+}
+
+// Heartbeat 记录已经被删除时重新登记
+func (r *MysqlNodeRegistry) Heartbeat(load *NodeLoad) error {
+	sql := `
+            update merkaba_node set state=?,runCount=?,idleCount=?,cpu=?,memory=?,chromeCount=?,freeVncPorts=?,heartbeatTime=?
+            where ip=?
+	`
+	result, err := r.Client.Update(sql, load.State, load.RunCount, load.IdleCount, load.Cpu, load.Memory,
+		load.ChromeCount, load.FreeVncPorts, load.HeartbeatTime, load.IP)
+	if err != nil {
+		LoggerStd.Error(sql, zap.Error(err))
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return r.Register(load)
+	}
+	return nil
+}
+
+func (r *MysqlNodeRegistry) SetState(state int) error {
+	sql := `
+            update merkaba_node set state=? where ip=?
+	`
+	var _, err = r.Client.Update(sql, state, LocalIP)
+	if err != nil {
+		LoggerStd.Error(sql, zap.Error(err))
+	}
+	return err
+}
+
+// Expire 没有上报过心跳(heartbeatTime=0)的节点不处理
+func (r *MysqlNodeRegistry) Expire(ttl time.Duration) (int, error) {
+	sql := `
+            update merkaba_node set state=? where state in (?,?) and heartbeatTime>0 and heartbeatTime<?
+	`
+	result, err := r.Client.Update(sql, NodeStateDown, NodeStateUp, NodeStateDraining, time.Now().Add(-ttl).UnixMilli())
+	if err != nil {
+		LoggerStd.Error(sql, zap.Error(err))
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+func (r *MysqlNodeRegistry) Nodes() ([]NodeLoad, error) {
+	sql := `
+            select ip,name,port,platform,state,runCount,idleCount,cpu,memory,chromeCount,freeVncPorts,heartbeatTime
+            from merkaba_node order by ip
+	`
+	result := make([]NodeLoad, 0)
+	err := r.Client.Select(&result, sql)
+	return result, err
+}
+
+// HazelcastNodeRegistry 节点保存在Hazelcast的Merkaba map,key是节点名称;心跳停止后由TTL自动删除
+type HazelcastNodeRegistry struct {
+	Client *HazelcastClient
+	TTL    time.Duration
+	lock   sync.Mutex
+	last   *NodeLoad
+}
+
+func (r *HazelcastNodeRegistry) put(load *NodeLoad) error {
+	b, err := json.Marshal(load)
+	if err != nil {
+		return err
+	}
+	err = r.Client.merkaba.SetWithTTL(context.Background(), load.Name, serialization.JSON(b), r.TTL)
+	if err != nil {
+		LoggerStd.Error("hazelcast node registry", zap.String("name", load.Name), zap.Error(err))
+		return err
+	}
+	r.last = load
+	return nil
+}
+
+func (r *HazelcastNodeRegistry) Register(load *NodeLoad) error {
+	return r.Heartbeat(load)
+}
+
+func (r *HazelcastNodeRegistry) Heartbeat(load *NodeLoad) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.put(load)
+}
+
+// SetState 下线时直接删除,其他状态按最近一次的负载重新上报
+func (r *HazelcastNodeRegistry) SetState(state int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if state == NodeStateDown {
+		r.last = nil
+		_, err := r.Client.merkaba.Remove(context.Background(), LocalName)
+		return err
+	}
+	if r.last == nil {
+		return nil
+	}
+	load := *r.last
+	load.State = state
+	load.HeartbeatTime = time.Now().UnixMilli()
+	return r.put(&load)
+}
+
+// Expire 过期由Hazelcast的TTL处理
+func (r *HazelcastNodeRegistry) Expire(ttl time.Duration) (int, error) {
+	return 0, nil
+}
+
+// Nodes Merkaba map中不是节点负载的数据会被忽略
+func (r *HazelcastNodeRegistry) Nodes() ([]NodeLoad, error) {
+	values, err := r.Client.merkaba.GetValues(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]NodeLoad, 0, len(values))
+	for _, v := range values {
+		data, ok := v.(serialization.JSON)
+		if !ok {
+			continue
+		}
+		var load NodeLoad
+		if json.Unmarshal(data, &load) != nil || len(load.IP) == 0 || load.State == 0 {
+			continue
+		}
+		result = append(result, load)
+	}
+	return result, nil
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// PauseGate 脚本的暂停点,VM在指令之间,WebPage在操作之前检查,暂停时阻塞到恢复
+type PauseGate struct {
+	paused atomic.Bool
+	lock   sync.Mutex
+	resume chan struct{}
+}
+
+// Pause 已经暂停时返回false
+func (g *PauseGate) Pause() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.resume != nil {
+		return false
+	}
+	g.resume = make(chan struct{})
+	g.paused.Store(true)
+	return true
+}
+
+// Resume 没有暂停时返回false
+func (g *PauseGate) Resume() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.resume == nil {
+		return false
+	}
+	close(g.resume)
+	g.resume = nil
+	g.paused.Store(false)
+	return true
+}
+
+func (g *PauseGate) Paused() bool {
+	return g.paused.Load()
+}
+
+// Wait 暂停时阻塞,直到Resume
+func (g *PauseGate) Wait() {
+	if !g.paused.Load() {
+		return
+	}
+	g.lock.Lock()
+	resume := g.resume
+	g.lock.Unlock()
+	if resume != nil {
+		<-resume
+	}
+}
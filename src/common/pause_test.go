@@ -0,0 +1,32 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPauseGate(t *testing.T) {
+	var g PauseGate
+	g.Wait()
+	if !g.Pause() || g.Pause() {
+		t.Fatal("pause twice")
+	}
+	resumed := make(chan struct{})
+	go func() {
+		g.Wait()
+		close(resumed)
+	}()
+	select {
+	case <-resumed:
+		t.Fatal("wait returned while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !g.Resume() || g.Resume() {
+		t.Fatal("resume twice")
+	}
+	select {
+	case <-resumed:
+	case <-time.After(time.Second):
+		t.Fatal("wait not resumed")
+	}
+}
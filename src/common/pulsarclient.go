@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/apache/pulsar-client-go/pulsar"
 	"go.uber.org/zap"
+	"merkaba/common/metrics"
 	"time"
 )
 
@@ -43,38 +44,59 @@ func (p *PulsarClient) Init(topic string, host string) {
 }
 
 func SendMessage(level string, ctx *RunContext, message string) {
+	info := newMessage(level, ctx, message)
+	Events.Publish(ctx.TaskName, info)
+	bytes, _ := json.Marshal(info)
 	for _, client := range PulsarMessageClients {
-		client._SendMessage(level, ctx.CookieId, ctx.TaskName, ctx.ScriptVersion, message)
+		client.send("SendMessage", bytes)
 	}
 }
 
-func (p *PulsarClient) _SendMessage(level string, _cookieId string, _taskName string, _scriptVersion string, message string) {
+// PublishMessage 只发给进程内的订阅者,不发送到pulsar
+func PublishMessage(level string, ctx *RunContext, message string) {
+	Events.Publish(ctx.TaskName, newMessage(level, ctx, message))
+}
+
+func newMessage(level string, ctx *RunContext, message string) map[string]any {
 	info := make(map[string]any)
 	info["type"] = "message"
 	info["server"] = LocalName
 	info["ip"] = LocalIP
 	info["level"] = level
-	info["cookieId"] = _cookieId
-	info["taskName"] = _taskName
-	info["scriptVersion"] = _scriptVersion
+	info["cookieId"] = ctx.CookieId
+	info["taskName"] = ctx.TaskName
+	info["scriptVersion"] = ctx.ScriptVersion
 	info["message"] = message
 	info["timestamp"] = time.Now().UnixMilli()
-	bytes, _ := json.Marshal(info)
+	return info
+}
+
+func (p *PulsarClient) send(name string, bytes []byte) error {
 	_, err := p.producer.Send(context.Background(), &pulsar.ProducerMessage{
 		Value: string(bytes),
 	})
 	if err != nil {
-		LoggerStd.Error("SendMessage", zap.NamedError("error", err))
+		metrics.PulsarSendFailures.WithLabelValues(name).Inc()
+		LoggerStd.Error(name, zap.NamedError("error", err))
 	}
+	return err
 }
 
 func SendLog(level string, ctx *RunContext, info map[string]any) {
+	info = newLog(level, ctx, info)
+	Events.Publish(ctx.TaskName, info)
+	bytes, _ := json.Marshal(info)
 	for _, client := range PulsarMessageClients {
-		client._SendLog(level, ctx, info)
+		client.send("SendLog", bytes)
 	}
 }
 
-func (p *PulsarClient) _SendLog(level string, ctx *RunContext, info map[string]any) {
+// PublishLog 只发给进程内的订阅者,不发送到pulsar
+func PublishLog(level string, ctx *RunContext, info map[string]any) {
+	Events.Publish(ctx.TaskName, newLog(level, ctx, info))
+}
+
+func newLog(level string, ctx *RunContext, info map[string]any) map[string]any {
 	info["type"] = "message"
 	info["cookieId"] = ctx.CookieId
 	info["taskName"] = ctx.TaskName
@@ -82,13 +104,7 @@ func (p *PulsarClient) _SendLog(level string, ctx *RunContext, info map[string]a
 	info["ip"] = LocalIP
 	info["level"] = level
 	info["timestamp"] = time.Now().UnixMilli()
-	bytes, _ := json.Marshal(info)
-	_, err := p.producer.Send(context.Background(), &pulsar.ProducerMessage{
-		Value: string(bytes),
-	})
-	if err != nil {
-		LoggerStd.Error("SendLog", zap.NamedError("error", err))
-	}
+	return info
 }
 
 func Notify(action string, info map[string]any) error {
@@ -103,11 +119,8 @@ func Notify(action string, info map[string]any) error {
 		return err
 	}
 	for _, client := range PulsarMessageClients {
-		_, err = client.producer.Send(context.Background(), &pulsar.ProducerMessage{
-			Value: string(bytes),
-		})
+		err = client.send("notify", bytes)
 		if err != nil {
-			LoggerStd.Error("notify", zap.NamedError("error", err))
 			return err
 		}
 	}
@@ -115,16 +128,6 @@ func Notify(action string, info map[string]any) error {
 }
 
 func SendData(_type string, _format string, ctx *RunContext, values map[string]any) error {
-	for _, client := range PulsarDataClients {
-		err := client._SendData(_type, _format, ctx, values)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (p *PulsarClient) _SendData(_type string, _format string, ctx *RunContext, values map[string]any) error {
 	data := make(map[string]any)
 	data["type"] = _type
 	data["format"] = _format
@@ -139,12 +142,12 @@ func (p *PulsarClient) _SendData(_type string, _format string, ctx *RunContext,
 		LoggerStd.Error("SendData", zap.NamedError("error", err))
 		return err
 	}
-	_, err = p.producer.Send(context.Background(), &pulsar.ProducerMessage{
-		Value: string(bytes),
-	})
-	if err != nil {
-		LoggerStd.Error("SendData", zap.NamedError("error", err))
-		return err
+	Events.Publish(ctx.TaskName, data)
+	for _, client := range PulsarDataClients {
+		err = client.send("SendData", bytes)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
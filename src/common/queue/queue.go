@@ -1,16 +1,22 @@
 package queue
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"os/signal"
+	"sort"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 )
 
-// Queue - A queue for enqueueing tasks to be processed
+// ErrQueueFull - Offer is rejected when the pending tasks reach the limit
+var ErrQueueFull = errors.New("queue is full")
+
+// Queue - A queue for enqueueing tasks to be processed.
+// Higher priorities are dispatched first, tasks of the same priority are
+// taken round-robin across sites, and a task is held back while its site
+// or account has reached the limit.
 type Queue struct {
 
 	// Debug Settings
@@ -18,79 +24,257 @@ type Queue struct {
 	tDebug bool        // enable debugging
 
 	// Channals
-	tQueueChan chan Task      // Task Channal
+	tNotify    chan struct{}  // wakes the dispatcher when tasks or slots change
 	tReadyChan chan chan Task // Ready Task Channals
 
 	// Goroutine synchronization
 	tDispatcherSync sync.WaitGroup // Work Dispatcher synchronization
 	tWorkersSync    sync.WaitGroup // Workers synchronization
 
-	// Queue Workers
+	// Queue Workers, guarded by tLock
 	tWorkers []*Worker
+	tRetire  int  // idle workers to retire for a smaller pool
+	tStarted bool // new workers of Resize are started at once
 
 	// Quit Queue
 	tQuit chan bool
-}
-
-// NewQueue - Creates a new Queue
-func NewQueue(nW int) *Queue {
 
-	// workers
-	w := make([]*Worker, nW, nW)
+	// Waiting tasks and running counters, guarded by tLock
+	tLock           sync.Mutex
+	tLevels         map[int]*level
+	tPriorities     []int // priorities of tLevels, descending
+	tLimits         Limits
+	tMaxPending     int // 0 means unbounded
+	tSiteRunning    map[string]int
+	tAccountRunning map[string]int
 
-	// workers synchronization
-	ws := sync.WaitGroup{}
+	// Statistics
+	tPending int64 // tasks waiting for a worker
+	tBusy    int64 // workers running a task
+}
 
-	// Ready Task Channals
-	rc := make(chan chan Task, nW)
+// level - Waiting tasks of one priority, grouped by site
+type level struct {
+	sites []string // round-robin order
+	next  int      // index of the site served next
+	tasks map[string][]*entry
+}
 
-	// create n Workers
-	for i := 0; i < nW; i++ {
-		w[i] = NewWorker(rc, ws)
-	}
+// NewQueue - Creates a new Queue
+func NewQueue(nW int) *Queue {
 
 	// return Queue
-	return &Queue{
+	q := &Queue{
 		// Channals
-		tQueueChan: make(chan Task),
-		tReadyChan: rc,
+		tNotify: make(chan struct{}, 1),
+		// Ready Task Channals
+		tReadyChan: make(chan chan Task, nW),
 
 		// Queue Workers
-		tWorkers: w,
-
-		// Goroutine synchronization
-		tDispatcherSync: sync.WaitGroup{},
-		tWorkersSync:    ws,
+		tWorkers: make([]*Worker, 0, nW),
 
 		// Quit Queue
 		tQuit: make(chan bool),
+
+		tLevels:         make(map[int]*level),
+		tSiteRunning:    make(map[string]int),
+		tAccountRunning: make(map[string]int),
+	}
+
+	// create n Workers
+	for i := 0; i < nW; i++ {
+		q.newWorker()
 	}
+	return q
 }
 
 // dispatch - Dispatch workers to process tasks
 func (q *Queue) dispatch() {
-	q.tDispatcherSync.Add(1)
 	for {
+		var workerChannel chan Task
 		select {
-		case Task := <-q.tQueueChan: // We got something in on our queue
-			workerChannel := <-q.tReadyChan // Check out an available worker
-			workerChannel <- Task           // Send the request to the channel
+		case workerChannel = <-q.tReadyChan: // Check out an available worker
 		case <-q.tQuit:
-			for i := 0; i < len(q.tWorkers); i++ {
-				q.tWorkers[i].Stop()
-			}
-			q.tWorkersSync.Wait()
-			q.tDispatcherSync.Done()
+			q.stopWorkers()
 			return
 		}
+		for {
+			if q.retireWorker(workerChannel) {
+				workerChannel <- retire{} // the worker is idle, no task is lost
+				break
+			}
+			if e := q.next(); e != nil {
+				workerChannel <- &assigned{q: q, e: e} // Send the request to the channel
+				break
+			}
+			select {
+			case <-q.tNotify: // a task was pushed, a running task released its limits or the pool was resized
+			case <-q.tQuit:
+				q.stopWorkers()
+				return
+			}
+		}
 	}
 }
 
+func (q *Queue) stopWorkers() {
+	q.tLock.Lock()
+	workers := q.tWorkers
+	q.tStarted = false
+	q.tLock.Unlock()
+	for i := 0; i < len(workers); i++ {
+		workers[i].Stop()
+	}
+	q.tWorkersSync.Wait()
+	q.tDispatcherSync.Done()
+}
+
+// retireWorker - Removes the idle worker of the channel while the pool is larger than its size
+func (q *Queue) retireWorker(workerChannel chan Task) bool {
+	q.tLock.Lock()
+	defer q.tLock.Unlock()
+	if q.tRetire == 0 {
+		return false
+	}
+	for i, w := range q.tWorkers {
+		if w.wAssignedTask == workerChannel {
+			q.tWorkers = append(q.tWorkers[:i:i], q.tWorkers[i+1:]...)
+			q.tRetire -= 1
+			return true
+		}
+	}
+	return false
+}
+
+// newWorker - Creates a worker of the queue, tLock is held
+func (q *Queue) newWorker() *Worker {
+	w := NewWorker(q.tReadyChan, &q.tWorkersSync)
+	w.wBusy = &q.tBusy
+	q.tWorkers = append(q.tWorkers, w)
+	return w
+}
+
+// next - Takes the next task allowed to run, nil when nothing can run now
+func (q *Queue) next() *entry {
+	q.tLock.Lock()
+	defer q.tLock.Unlock()
+	for _, priority := range q.tPriorities {
+		l := q.tLevels[priority]
+		for i := 0; i < len(l.sites); i++ {
+			index := (l.next + i) % len(l.sites)
+			site := l.sites[index]
+			if limit := q.tLimits.site(site); limit > 0 && len(site) > 0 && q.tSiteRunning[site] >= limit {
+				continue
+			}
+			tasks := l.tasks[site]
+			for j, e := range tasks {
+				account := e.info.Account
+				if q.tLimits.Account > 0 && len(account) > 0 && q.tAccountRunning[account] >= q.tLimits.Account {
+					continue
+				}
+				l.tasks[site] = append(tasks[:j:j], tasks[j+1:]...)
+				if len(l.tasks[site]) == 0 {
+					q.removeSite(priority, l, index)
+				} else {
+					l.next = (index + 1) % len(l.sites)
+				}
+				q.increase(q.tSiteRunning, site)
+				q.increase(q.tAccountRunning, account)
+				atomic.AddInt64(&q.tPending, -1)
+				if e.onDispatch != nil {
+					e.onDispatch()
+				}
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// removeSite - Removes a site without waiting tasks, the caller holds tLock
+func (q *Queue) removeSite(priority int, l *level, index int) {
+	delete(l.tasks, l.sites[index])
+	l.sites = append(l.sites[:index], l.sites[index+1:]...)
+	if len(l.sites) == 0 {
+		delete(q.tLevels, priority)
+		q.sortPriorities()
+		return
+	}
+	l.next = index % len(l.sites)
+}
+
+func (q *Queue) sortPriorities() {
+	q.tPriorities = q.tPriorities[:0]
+	for priority := range q.tLevels {
+		q.tPriorities = append(q.tPriorities, priority)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(q.tPriorities)))
+}
+
+// release - A task finished, frees its site and account
+func (q *Queue) release(info TaskInfo) {
+	q.tLock.Lock()
+	q.decrease(q.tSiteRunning, info.Site)
+	q.decrease(q.tAccountRunning, info.Account)
+	q.tLock.Unlock()
+	q.notify()
+}
+
+func (q *Queue) increase(running map[string]int, key string) {
+	if len(key) > 0 {
+		running[key] += 1
+	}
+}
+
+func (q *Queue) decrease(running map[string]int, key string) {
+	if len(key) == 0 {
+		return
+	}
+	if running[key] <= 1 {
+		delete(running, key)
+	} else {
+		running[key] -= 1
+	}
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.tNotify <- struct{}{}:
+	default:
+	}
+}
+
+// SetLimits - Changes the concurrency limits, running tasks are not affected
+func (q *Queue) SetLimits(limits Limits) {
+	q.tLock.Lock()
+	q.tLimits = limits
+	q.tLock.Unlock()
+	q.notify()
+}
+
+// Limits - Current concurrency limits
+func (q *Queue) Limits() Limits {
+	q.tLock.Lock()
+	defer q.tLock.Unlock()
+	return q.tLimits
+}
+
+// SetMaxPending - Limits the tasks waiting for a worker, 0 means unbounded
+func (q *Queue) SetMaxPending(n int) {
+	q.tLock.Lock()
+	q.tMaxPending = n
+	q.tLock.Unlock()
+}
+
 // Start - Starts the worker and dispatcher go routines
 func (q *Queue) Start() {
+	q.tLock.Lock()
 	for i := 0; i < len(q.tWorkers); i++ {
 		q.tWorkers[i].Start() // start workers
 	}
+	q.tStarted = true
+	q.tLock.Unlock()
+	q.tDispatcherSync.Add(1)
 	go q.dispatch() // queue dispach
 }
 
@@ -100,9 +284,217 @@ func (q *Queue) Stop() {
 	q.tDispatcherSync.Wait() // wait
 }
 
+// Resize - Changes the number of workers. Growing starts new workers at once,
+// shrinking retires workers when they are idle, running tasks are not affected.
+func (q *Queue) Resize(n int) {
+	if n <= 0 {
+		return
+	}
+	q.tLock.Lock()
+	size := len(q.tWorkers) - q.tRetire
+	if n < size {
+		q.tRetire += size - n
+	} else if n > size {
+		add := n - size
+		// cancel pending retirements before creating workers
+		cancel := add
+		if cancel > q.tRetire {
+			cancel = q.tRetire
+		}
+		q.tRetire -= cancel
+		for i := 0; i < add-cancel; i++ {
+			w := q.newWorker()
+			if q.tStarted {
+				w.Start()
+			}
+		}
+	}
+	q.tLock.Unlock()
+	q.notify()
+}
+
+// Size - Number of workers after the pending retirements
+func (q *Queue) Size() int {
+	q.tLock.Lock()
+	defer q.tLock.Unlock()
+	return len(q.tWorkers) - q.tRetire
+}
+
+// Pending - Number of tasks waiting for a worker
+func (q *Queue) Pending() int {
+	return int(atomic.LoadInt64(&q.tPending))
+}
+
+// Busy - Number of workers running a task
+func (q *Queue) Busy() int {
+	return int(atomic.LoadInt64(&q.tBusy))
+}
+
+// push - Adds a task to the waiting tasks of its priority and site
+func (q *Queue) push(e *entry) {
+	q.classify(e)
+	q.tLock.Lock()
+	q.add(e)
+	q.tLock.Unlock()
+	q.notify()
+}
+
+func (q *Queue) classify(e *entry) {
+	if c, ok := e.task.(Classified); ok {
+		e.info = c.QueueInfo()
+	}
+	if e.info.Priority <= 0 {
+		e.info.Priority = PriorityNormal
+	}
+}
+
+// add - The caller holds tLock
+func (q *Queue) add(e *entry) {
+	l, ok := q.tLevels[e.info.Priority]
+	if !ok {
+		l = &level{tasks: make(map[string][]*entry)}
+		q.tLevels[e.info.Priority] = l
+		q.sortPriorities()
+	}
+	if _, ok := l.tasks[e.info.Site]; !ok {
+		l.sites = append(l.sites, e.info.Site)
+	}
+	l.tasks[e.info.Site] = append(l.tasks[e.info.Site], e)
+	atomic.AddInt64(&q.tPending, 1)
+}
+
+// find - The waiting entry of a task, the caller holds tLock
+func (q *Queue) find(task Task) *entry {
+	for _, l := range q.tLevels {
+		for _, tasks := range l.tasks {
+			for _, e := range tasks {
+				if e.task == task {
+					return e
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// position - Estimated position of a waiting entry, the caller holds tLock.
+// Counts the tasks of higher priorities and the tasks taken before it by round-robin.
+func (q *Queue) position(e *entry) int {
+	ahead := 0
+	for _, priority := range q.tPriorities {
+		l := q.tLevels[priority]
+		if priority > e.info.Priority {
+			for _, tasks := range l.tasks {
+				ahead += len(tasks)
+			}
+			continue
+		}
+		if priority < e.info.Priority {
+			break
+		}
+		index := 0
+		for i, t := range l.tasks[e.info.Site] {
+			if t == e {
+				index = i
+			}
+		}
+		own := 0
+		for i, site := range l.sites {
+			if site == e.info.Site {
+				own = (i - l.next + len(l.sites)) % len(l.sites)
+			}
+		}
+		for i, site := range l.sites {
+			if site == e.info.Site {
+				continue
+			}
+			rounds := index
+			if (i-l.next+len(l.sites))%len(l.sites) < own {
+				rounds += 1
+			}
+			if n := len(l.tasks[site]); n < rounds {
+				rounds = n
+			}
+			ahead += rounds
+		}
+		ahead += index
+	}
+	return ahead + 1
+}
+
+// remove - Removes a waiting task, false when it was already dispatched
+func (q *Queue) remove(e *entry) bool {
+	q.tLock.Lock()
+	defer q.tLock.Unlock()
+	return q.delete(e)
+}
+
+// delete - The caller holds tLock
+func (q *Queue) delete(e *entry) bool {
+	l, ok := q.tLevels[e.info.Priority]
+	if !ok {
+		return false
+	}
+	tasks := l.tasks[e.info.Site]
+	for i, t := range tasks {
+		if t != e {
+			continue
+		}
+		l.tasks[e.info.Site] = append(tasks[:i:i], tasks[i+1:]...)
+		if len(l.tasks[e.info.Site]) == 0 {
+			for index, site := range l.sites {
+				if site == e.info.Site {
+					q.removeSite(e.info.Priority, l, index)
+					break
+				}
+			}
+		}
+		atomic.AddInt64(&q.tPending, -1)
+		return true
+	}
+	return false
+}
+
 // Enqueue - Fire-and-forget task are executed only once.
 func (q *Queue) Enqueue(Task Task) {
-	q.tQueueChan <- Task
+	q.push(&entry{task: Task})
+}
+
+// Offer - Like Enqueue but returns ErrQueueFull instead of exceeding the pending limit,
+// position is the estimated position of the task, 1 is the next to run.
+func (q *Queue) Offer(Task Task) (position int, err error) {
+	e := &entry{task: Task}
+	q.classify(e)
+	q.tLock.Lock()
+	if q.tMaxPending > 0 && q.Pending() >= q.tMaxPending {
+		q.tLock.Unlock()
+		return 0, ErrQueueFull
+	}
+	q.add(e)
+	position = q.position(e)
+	q.tLock.Unlock()
+	q.notify()
+	return position, nil
+}
+
+// Position - Estimated position of a waiting task, 0 when it is not waiting
+func (q *Queue) Position(Task Task) int {
+	q.tLock.Lock()
+	defer q.tLock.Unlock()
+	if e := q.find(Task); e != nil {
+		return q.position(e)
+	}
+	return 0
+}
+
+// Remove - Removes a waiting task, false when it is not waiting
+func (q *Queue) Remove(Task Task) bool {
+	q.tLock.Lock()
+	defer q.tLock.Unlock()
+	if e := q.find(Task); e != nil {
+		return q.delete(e)
+	}
+	return false
 }
 
 // Schedule - Delayed task are executed only once too, but not immediately, after a certain time interval.
@@ -112,34 +504,39 @@ func (q *Queue) Schedule(Task Task, duration_string string) {
 		t := time.NewTicker(dur)
 		defer t.Stop()
 		<-t.C
-		q.tQueueChan <- Task
+		q.Enqueue(Task)
 	}()
 }
 
-// Recurring - Recurring task are executed every x duration
-func (q *Queue) Recurring(Task Task, duration_string string) {
-	dur, _ := time.ParseDuration(duration_string)
+// Recurring - Recurring task are executed every x duration until the returned stop function is called.
+// A tick is dropped while the previous one is still waiting for a worker.
+func (q *Queue) Recurring(Task Task, duration_string string) (stop func(), err error) {
+	dur, err := time.ParseDuration(duration_string)
+	if err != nil {
+		return nil, err
+	}
+	if dur <= 0 {
+		return nil, fmt.Errorf("invalid duration %s", duration_string)
+	}
+	quit := make(chan struct{})
 	go func() {
-		// Signals to stop timer
-		sigs := make(chan os.Signal, 1)
-		sigdone := make(chan bool, 1)
-		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 		t := time.NewTicker(dur)
-		go func() {
-			sig := <-sigs
-			fmt.Println(sig)
-			t.Stop() // stop timer
-			sigdone <- true
-		}()
-
-		// main loop
+		defer t.Stop()
+		var waiting atomic.Bool
+		var last *entry
 		for {
-			go func() {
-				q.tQueueChan <- Task // run task
-			}()
-			<-t.C
+			if waiting.CompareAndSwap(false, true) {
+				last = &entry{task: Task, onDispatch: func() { waiting.Store(false) }}
+				q.push(last) // run task
+			}
+			select {
+			case <-t.C:
+			case <-quit:
+				q.remove(last)
+				return
+			}
 		}
-
 	}()
-
+	var once sync.Once
+	return func() { once.Do(func() { close(quit) }) }, nil
 }
@@ -0,0 +1,235 @@
+package queue
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countTask struct {
+	count int64
+}
+
+func (t *countTask) Run() {
+	atomic.AddInt64(&t.count, 1)
+}
+
+func TestRecurring(t *testing.T) {
+	q := NewQueue(2)
+	q.Start()
+	task := &countTask{}
+	stop, err := q.Recurring(task, "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(55 * time.Millisecond)
+	stop()
+	stop()
+	time.Sleep(20 * time.Millisecond)
+	count := atomic.LoadInt64(&task.count)
+	if count < 3 {
+		t.Fatalf("expected at least 3 runs, got %d", count)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt64(&task.count) != count {
+		t.Fatal("task still running after stop")
+	}
+	if q.Pending() != 0 || q.Busy() != 0 {
+		t.Fatalf("pending=%d busy=%d", q.Pending(), q.Busy())
+	}
+	q.Stop()
+
+	if _, err = q.Recurring(task, "abc"); err == nil {
+		t.Fatal("expected error for invalid duration")
+	}
+}
+
+type siteTask struct {
+	info    TaskInfo
+	name    string
+	order   chan string
+	release chan struct{}
+}
+
+func (t *siteTask) QueueInfo() TaskInfo {
+	return t.info
+}
+
+func (t *siteTask) Run() {
+	t.order <- t.name
+	if t.release != nil {
+		<-t.release
+	}
+}
+
+func TestPriorityAndRoundRobin(t *testing.T) {
+	q := NewQueue(1)
+	order := make(chan string, 10)
+	block := &siteTask{name: "block", order: order, release: make(chan struct{})}
+	q.Start()
+	defer q.Stop()
+	q.Enqueue(block)
+	if name := <-order; name != "block" {
+		t.Fatalf("first task %s", name)
+	}
+	/*唯一的worker被占用,下面的任务都在等待*/
+	q.Enqueue(&siteTask{name: "a1", info: TaskInfo{Priority: PriorityLow, Site: "a"}, order: order})
+	q.Enqueue(&siteTask{name: "a2", info: TaskInfo{Priority: PriorityLow, Site: "a"}, order: order})
+	q.Enqueue(&siteTask{name: "b1", info: TaskInfo{Priority: PriorityLow, Site: "b"}, order: order})
+	q.Enqueue(&siteTask{name: "h1", info: TaskInfo{Priority: PriorityHigh, Site: "a"}, order: order})
+	if q.Pending() != 4 {
+		t.Fatalf("pending=%d", q.Pending())
+	}
+	close(block.release)
+	expected := []string{"h1", "a1", "b1", "a2"}
+	for _, want := range expected {
+		select {
+		case name := <-order:
+			if name != want {
+				t.Fatalf("expected %s, got %s", want, name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not dispatched", want)
+		}
+	}
+}
+
+func TestSiteLimit(t *testing.T) {
+	q := NewQueue(3)
+	q.SetLimits(Limits{Site: 1, Sites: map[string]int{"b": 2}})
+	order := make(chan string, 10)
+	release := make(chan struct{})
+	q.Start()
+	defer q.Stop()
+	q.Enqueue(&siteTask{name: "a1", info: TaskInfo{Site: "a"}, order: order, release: release})
+	q.Enqueue(&siteTask{name: "a2", info: TaskInfo{Site: "a"}, order: order, release: release})
+	q.Enqueue(&siteTask{name: "b1", info: TaskInfo{Site: "b"}, order: order, release: release})
+	q.Enqueue(&siteTask{name: "b2", info: TaskInfo{Site: "b"}, order: order, release: release})
+	started := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		select {
+		case name := <-order:
+			started[name] = true
+		case <-time.After(time.Second):
+			t.Fatalf("only %v started", started)
+		}
+	}
+	if started["a2"] || q.Pending() != 1 {
+		t.Fatalf("site limit not applied %v pending=%d", started, q.Pending())
+	}
+	close(release)
+	select {
+	case name := <-order:
+		if name != "a2" {
+			t.Fatalf("expected a2, got %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a2 not dispatched after release")
+	}
+}
+
+func TestOfferAndRemove(t *testing.T) {
+	q := NewQueue(1)
+	q.SetMaxPending(2)
+	order := make(chan string, 10)
+	block := &siteTask{name: "block", order: order, release: make(chan struct{})}
+	q.Start()
+	defer q.Stop()
+	q.Enqueue(block)
+	<-order
+	a := &siteTask{name: "a", info: TaskInfo{Site: "a"}, order: order}
+	b := &siteTask{name: "b", info: TaskInfo{Site: "b"}, order: order}
+	if position, err := q.Offer(a); err != nil || position != 1 {
+		t.Fatalf("offer a position=%d err=%v", position, err)
+	}
+	if position, err := q.Offer(b); err != nil || position != 2 {
+		t.Fatalf("offer b position=%d err=%v", position, err)
+	}
+	if _, err := q.Offer(&siteTask{name: "c", order: order}); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	if !q.Remove(a) || q.Remove(a) {
+		t.Fatal("remove a")
+	}
+	if q.Position(b) != 1 || q.Pending() != 1 {
+		t.Fatalf("position=%d pending=%d", q.Position(b), q.Pending())
+	}
+	close(block.release)
+	if name := <-order; name != "b" {
+		t.Fatalf("expected b, got %s", name)
+	}
+}
+
+func (q *Queue) workers() int {
+	q.tLock.Lock()
+	defer q.tLock.Unlock()
+	return len(q.tWorkers)
+}
+
+func waitWorkers(t *testing.T, q *Queue, n int) {
+	for i := 0; i < 100 && q.workers() != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if q.workers() != n {
+		t.Fatalf("expected %d workers, got %d", n, q.workers())
+	}
+}
+
+func TestResize(t *testing.T) {
+	q := NewQueue(4)
+	q.Start()
+	defer q.Stop()
+	q.Resize(1)
+	waitWorkers(t, q, 1)
+
+	q.Resize(3)
+	if q.Size() != 3 {
+		t.Fatalf("size %d", q.Size())
+	}
+	order := make(chan string, 10)
+	release := make(chan struct{})
+	for _, name := range []string{"a", "b", "c"} {
+		q.Enqueue(&siteTask{name: name, order: order, release: release})
+	}
+	for i := 0; i < 3; i++ {
+		<-order
+	}
+	/*缩小时运行中的任务不受影响,worker空闲后才退出*/
+	q.Resize(1)
+	time.Sleep(30 * time.Millisecond)
+	if q.workers() != 3 || q.Busy() != 3 {
+		t.Fatalf("workers=%d busy=%d", q.workers(), q.Busy())
+	}
+	close(release)
+	waitWorkers(t, q, 1)
+	if q.Size() != 1 {
+		t.Fatalf("size %d", q.Size())
+	}
+}
+
+func TestResizeBeyondInitialSize(t *testing.T) {
+	q := NewQueue(2)
+	q.Start()
+	q.Resize(6)
+	waitWorkers(t, q, 6)
+	order := make(chan string, 10)
+	release := make(chan struct{})
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		q.Enqueue(&siteTask{name: name, order: order, release: release})
+	}
+	for i := 0; i < 6; i++ {
+		<-order
+	}
+	close(release)
+	/*超过初始大小的worker阻塞在ready通道时也要能退出*/
+	stopped := make(chan struct{})
+	go func() {
+		q.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop blocked")
+	}
+}
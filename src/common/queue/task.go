@@ -4,3 +4,55 @@ package queue
 type Task interface {
 	Run()
 }
+
+// Task priorities, higher runs first
+const (
+	PriorityLow    = 1 // batch and scheduled runs
+	PriorityNormal = 2 // default
+	PriorityHigh   = 3 // interactive debugging
+)
+
+// TaskInfo - Scheduling attributes of a task
+type TaskInfo struct {
+	Priority int    // PriorityLow .. PriorityHigh, 0 means PriorityNormal
+	Site     string // tasks of one site share the site limit
+	Account  string // tasks of one account share the account limit
+}
+
+// Classified - Tasks implementing it are scheduled by their TaskInfo,
+// other tasks run with PriorityNormal and no limits.
+type Classified interface {
+	QueueInfo() TaskInfo
+}
+
+// Limits - Concurrency limits of running tasks, 0 means unlimited
+type Limits struct {
+	Site    int            // running tasks per site
+	Account int            // running tasks per account
+	Sites   map[string]int // overrides Site for the given sites
+}
+
+func (l *Limits) site(name string) int {
+	if v, ok := l.Sites[name]; ok {
+		return v
+	}
+	return l.Site
+}
+
+// entry - A task waiting in the queue
+type entry struct {
+	task       Task
+	info       TaskInfo
+	onDispatch func()
+}
+
+// assigned - A dispatched task, releases its site and account when done
+type assigned struct {
+	q *Queue
+	e *entry
+}
+
+func (a *assigned) Run() {
+	defer a.q.release(a.e.info)
+	a.e.task.Run()
+}
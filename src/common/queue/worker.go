@@ -3,6 +3,7 @@ package queue
 import (
 	"log"
 	"sync"
+	"sync/atomic"
 )
 
 // Worker - Worker that procresses tasks
@@ -17,14 +18,17 @@ type Worker struct {
 	wAssignedTask chan Task
 
 	// worker synchronization
-	wIsDone sync.WaitGroup
+	wIsDone *sync.WaitGroup
 
 	// worker quit
 	wQuit chan bool
+
+	// busy workers counter of the queue
+	wBusy *int64
 }
 
 // NewWorker - Creates a new worker
-func NewWorker(readyPool chan chan Task, done sync.WaitGroup) *Worker {
+func NewWorker(readyPool chan chan Task, done *sync.WaitGroup) *Worker {
 	return &Worker{
 		wReadyChan:    readyPool,
 		wAssignedTask: make(chan Task),
@@ -35,13 +39,23 @@ func NewWorker(readyPool chan chan Task, done sync.WaitGroup) *Worker {
 
 // Start - Begins processing worker's task
 func (w *Worker) Start() {
+	w.wIsDone.Add(1)
 	go func() {
-		w.wIsDone.Add(1)
 		for {
-			w.wReadyChan <- w.wAssignedTask // check the Task queue in
+			// check the Task queue in, the ready channel is full while the pool is larger than its initial size
+			select {
+			case w.wReadyChan <- w.wAssignedTask:
+			case <-w.wQuit:
+				w.wIsDone.Done()
+				return
+			}
 			select {
 			case Task := <-w.wAssignedTask: // see if anything has been assigned to the queue
-				Task.Run()
+				if _, ok := Task.(retire); ok {
+					w.wIsDone.Done()
+					return
+				}
+				w.run(Task)
 			case <-w.wQuit:
 				w.wIsDone.Done()
 				return
@@ -54,3 +68,17 @@ func (w *Worker) Start() {
 func (w *Worker) Stop() {
 	w.wQuit <- true
 }
+
+// retire - Sent by the dispatcher to an idle worker to shrink the pool
+type retire struct{}
+
+func (retire) Run() {}
+
+// run - Runs the task and keeps the busy counter
+func (w *Worker) run(Task Task) {
+	if w.wBusy != nil {
+		atomic.AddInt64(w.wBusy, 1)
+		defer atomic.AddInt64(w.wBusy, -1)
+	}
+	Task.Run()
+}
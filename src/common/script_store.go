@@ -0,0 +1,275 @@
+package common
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/*server.yaml中script.store的取值*/
+const (
+	ScriptStoreMysql = "mysql"
+	ScriptStoreFile  = "file"
+	ScriptStoreGit   = "git"
+)
+
+/*脚本的发布渠道,canary按灰度规则使用候选版本*/
+const (
+	ScriptChannelDev    = "dev"
+	ScriptChannelPrd    = "prd"
+	ScriptChannelCanary = "canary"
+)
+
+const scriptExt = ".js"
+
+var ErrInvalidScriptUri = errors.New("invalid script uri")
+
+// ScriptStore 按id或者uri读取脚本的内容和版本,不存在时返回空
+type ScriptStore interface {
+	ReadScript(id string) (content string, version string)
+	ReadScriptId(uri string) string
+}
+
+// VersionedScriptStore 可以读取脚本的历史版本
+type VersionedScriptStore interface {
+	ReadScriptVersion(id string, version string) (content string, resolved string)
+}
+
+// ChannelScriptStore 按发布渠道(dev,prd)读取脚本
+type ChannelScriptStore interface {
+	ReadScriptChannel(id string, channel string) (content string, version string)
+}
+
+// DefaultScriptChannel 没有指定渠道时按环境选择
+func DefaultScriptChannel() string {
+	if Env != nil && Env.Environment.Production {
+		return ScriptChannelPrd
+	}
+	return ScriptChannelDev
+}
+
+// ReadScriptByUri 先按uri查找id,再读取脚本
+func ReadScriptByUri(store ScriptStore, uri string) (content string, version string) {
+	id := store.ReadScriptId(uri)
+	if len(id) == 0 {
+		return "", ""
+	}
+	return store.ReadScript(id)
+}
+
+// NewScriptStore 按server.yaml的script配置创建,默认使用mysql
+func NewScriptStore(env *YamlFile, client *MysqlClient) (ScriptStore, error) {
+	switch env.Script.Store {
+	case "", ScriptStoreMysql:
+		return &MysqlScriptStore{Client: client, Production: env.Environment.Production}, nil
+	case ScriptStoreFile:
+		if len(env.Script.Root) == 0 {
+			return nil, errors.New("script.root is required for file store")
+		}
+		return &FileScriptStore{Root: env.Script.Root}, nil
+	case ScriptStoreGit:
+		if len(env.Script.Root) == 0 {
+			return nil, errors.New("script.root is required for git store")
+		}
+		ref := env.Script.Ref
+		if len(ref) == 0 {
+			ref = "HEAD"
+		}
+		return &GitScriptStore{Root: env.Script.Root, Dir: env.Script.Dir, Ref: ref}, nil
+	default:
+		return nil, fmt.Errorf("unknown script store %s", env.Script.Store)
+	}
+}
+
+// MysqlScriptStore 脚本保存在merkaba表,内容和版本在merkaba_script_prd或者merkaba_script_dev表
+type MysqlScriptStore struct {
+	Client     *MysqlClient
+	Production bool
+}
+
+type scriptInfo struct {
+	Content string `db:"content"`
+	Version string `db:"version"`
+}
+
+func (s *MysqlScriptStore) ReadScriptId(uri string) string {
+	var id []string
+	s.Client.Select(&id, "select id from merkaba where uri=?", uri)
+	if len(id) == 0 {
+		return ""
+	}
+	return id[0]
+}
+
+func (s *MysqlScriptStore) ReadScript(id string) (content string, version string) {
+	if s.Production {
+		return s.ReadScriptChannel(id, ScriptChannelPrd)
+	}
+	return s.ReadScriptChannel(id, ScriptChannelDev)
+}
+
+func (s *MysqlScriptStore) ReadScriptChannel(id string, channel string) (content string, version string) {
+	var sql string
+	if channel == ScriptChannelPrd {
+		sql = `select content,version from merkaba_script_prd where id=?`
+	} else {
+		sql = `select content,version from merkaba_script_dev where id=?`
+	}
+	var result scriptInfo
+	var err = s.Client.Get(&result, sql, id)
+	if err != nil {
+		LoggerStd.Error(sql, zap.Error(err))
+		return "", ""
+	}
+	return result.Content, result.Version
+}
+
+// ReadScriptVersion 发布过的版本保存在merkaba_script_version表,还没有保存的当前版本从merkaba_script_prd和merkaba_script_dev表读取
+func (s *MysqlScriptStore) ReadScriptVersion(id string, version string) (content string, resolved string) {
+	sqls := []string{
+		`select content,version from merkaba_script_version where scriptId=? and version=?`,
+		`select content,version from merkaba_script_prd where id=? and version=?`,
+		`select content,version from merkaba_script_dev where id=? and version=?`,
+	}
+	for _, sql := range sqls {
+		var result []scriptInfo
+		if err := s.Client.Select(&result, sql, id, version); err != nil {
+			LoggerStd.Error(sql, zap.Error(err))
+			return "", ""
+		}
+		if len(result) > 0 {
+			return result[0].Content, result[0].Version
+		}
+	}
+	return "", ""
+}
+
+// scriptPath uri转换为相对路径 siteName/.../name.js,不允许跳出根目录
+func scriptPath(uri string) (string, error) {
+	uri = strings.TrimSuffix(strings.Trim(uri, "/"), scriptExt)
+	if len(uri) == 0 {
+		return "", ErrInvalidScriptUri
+	}
+	path := filepath.Clean(uri)
+	if path == "." || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidScriptUri
+	}
+	return filepath.ToSlash(path) + scriptExt, nil
+}
+
+// contentVersion 本地文件没有版本号,使用内容的hash
+func contentVersion(content []byte) string {
+	sum := sha1.Sum(content)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// FileScriptStore 脚本保存在本地目录 {root}/{siteName}/.../name.js,id和uri相同
+type FileScriptStore struct {
+	Root string
+}
+
+func (s *FileScriptStore) ReadScriptId(uri string) string {
+	path, err := scriptPath(uri)
+	if err != nil {
+		return ""
+	}
+	if !CheckFileExist(filepath.Join(s.Root, path)) {
+		return ""
+	}
+	return strings.TrimSuffix(path, scriptExt)
+}
+
+func (s *FileScriptStore) ReadScript(id string) (content string, version string) {
+	path, err := scriptPath(id)
+	if err != nil {
+		return "", ""
+	}
+	data, err := os.ReadFile(filepath.Join(s.Root, path))
+	if err != nil {
+		LoggerStd.Error("read script", zap.String("id", id), zap.Error(err))
+		return "", ""
+	}
+	return string(data), contentVersion(data)
+}
+
+// GitScriptStore 从git仓库的指定ref读取脚本,不需要checkout,版本是ref当前指向的commit
+type GitScriptStore struct {
+	Root string // 仓库目录
+	Dir  string // 脚本在仓库中的子目录
+	Ref  string
+}
+
+func (s *GitScriptStore) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", s.Root}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *GitScriptStore) object(id string) (string, error) {
+	path, err := scriptPath(id)
+	if err != nil {
+		return "", err
+	}
+	if len(s.Dir) > 0 {
+		path = strings.Trim(filepath.ToSlash(s.Dir), "/") + "/" + path
+	}
+	return path, nil
+}
+
+func (s *GitScriptStore) commit() (string, error) {
+	out, err := s.git("rev-parse", "--verify", s.Ref+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (s *GitScriptStore) ReadScriptId(uri string) string {
+	path, err := s.object(uri)
+	if err != nil {
+		return ""
+	}
+	if _, err = s.git("cat-file", "-e", s.Ref+":"+path); err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.Trim(uri, "/"), scriptExt)
+}
+
+// ReadScriptVersion version是commit或者tag
+func (s *GitScriptStore) ReadScriptVersion(id string, version string) (content string, resolved string) {
+	pinned := *s
+	pinned.Ref = version
+	return pinned.ReadScript(id)
+}
+
+func (s *GitScriptStore) ReadScript(id string) (content string, version string) {
+	path, err := s.object(id)
+	if err != nil {
+		return "", ""
+	}
+	/*先解析commit,内容和版本来自同一个commit*/
+	commit, err := s.commit()
+	if err != nil {
+		LoggerStd.Error("read script", zap.String("id", id), zap.Error(err))
+		return "", ""
+	}
+	data, err := s.git("show", commit+":"+path)
+	if err != nil {
+		LoggerStd.Error("read script", zap.String("id", id), zap.Error(err))
+		return "", ""
+	}
+	return string(data), commit[:12]
+}
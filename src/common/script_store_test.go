@@ -0,0 +1,78 @@
+package common
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func writeScript(t *testing.T, root string, path string, content string) {
+	file := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileScriptStore(t *testing.T) {
+	root := t.TempDir()
+	writeScript(t, root, "jd.com/login.js", "console.log('login')")
+	store := &FileScriptStore{Root: root}
+	id := store.ReadScriptId("jd.com/login")
+	if id != "jd.com/login" {
+		t.Fatalf("id %s", id)
+	}
+	content, version := store.ReadScript(id)
+	if content != "console.log('login')" || len(version) == 0 {
+		t.Fatalf("content %s version %s", content, version)
+	}
+	if store.ReadScriptId("jd.com/none") != "" || store.ReadScriptId("../etc/passwd") != "" {
+		t.Fatal("expected empty id")
+	}
+	if content, _ = ReadScriptByUri(store, "/jd.com/login.js"); len(content) == 0 {
+		t.Fatal("expected content by uri")
+	}
+}
+
+func TestGitScriptStore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", root, "-c", "user.name=test", "-c", "user.email=test@test"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	git("init", "-q")
+	writeScript(t, root, "scripts/jd.com/login.js", "v1")
+	git("add", "-A")
+	git("commit", "-q", "-m", "v1")
+	git("tag", "v1")
+	writeScript(t, root, "scripts/jd.com/login.js", "v2")
+	git("commit", "-q", "-am", "v2")
+
+	store := &GitScriptStore{Root: root, Dir: "scripts", Ref: "v1"}
+	id := store.ReadScriptId("jd.com/login")
+	if id != "jd.com/login" {
+		t.Fatalf("id %s", id)
+	}
+	content, version := store.ReadScript(id)
+	if content != "v1" || len(version) != 12 {
+		t.Fatalf("content %s version %s", content, version)
+	}
+	store.Ref = "HEAD"
+	if content, _ = store.ReadScript(id); content != "v2" {
+		t.Fatalf("content %s", content)
+	}
+	if content, _ = store.ReadScriptVersion(id, version); content != "v1" {
+		t.Fatalf("pinned content %s", content)
+	}
+	if store.ReadScriptId("jd.com/none") != "" {
+		t.Fatal("expected empty id")
+	}
+}
@@ -29,12 +29,14 @@ import (
 	"image"
 	"image/color"
 	"image/jpeg"
+	"merkaba/common/metrics"
 	"os"
-	"sync/atomic"
+	"sync"
 	"unsafe"
 )
 
-var VncPortFrom uint64 = 4320
+var VncPortFrom int = 4320
+var VncPortCount int = 100 /*端口范围 VncPortFrom+1 ~ VncPortFrom+VncPortCount*/
 var VncWidth int = 900
 var VncHeight int = 600
 var VncBPP int = 4
@@ -47,7 +49,18 @@ type VncInstance struct {
 	Screen   uintptr
 }
 
-var VncInstances = make(map[string]*VncInstance)
+/*VNC回调、http请求和页面刷新并发访问,需要持有vncLock*/
+var (
+	VncInstances = make(map[string]*VncInstance)
+	vncLock      sync.Mutex
+	vncNextPort  int
+)
+
+func findVNC(taskName string) *VncInstance {
+	vncLock.Lock()
+	defer vncLock.Unlock()
+	return VncInstances[taskName]
+}
 
 //export onMouseCallback
 func onMouseCallback(taskName *C.char, button int, x int, y int) {
@@ -73,25 +86,53 @@ func onConnectionCallback(taskName *C.char, clientCount int) {
 }
 
 func HasVNC(taskName string) bool {
-	if _, ok := VncInstances[taskName]; ok {
-		return true
-	} else {
-		return false
+	return findVNC(taskName) != nil
+}
+
+// FreeVncPorts 端口范围内没有被使用的端口数量
+func FreeVncPorts() int {
+	vncLock.Lock()
+	defer vncLock.Unlock()
+	return VncPortCount - len(VncInstances)
+}
+
+/*从上次分配的位置开始查找空闲端口,刚释放的端口最后才会被重新使用;调用时持有vncLock*/
+func nextVncPort() int {
+	used := make(map[int]bool, len(VncInstances))
+	for _, i := range VncInstances {
+		used[i.Port] = true
+	}
+	for i := 0; i < VncPortCount; i++ {
+		offset := (vncNextPort + i) % VncPortCount
+		if port := VncPortFrom + 1 + offset; !used[port] {
+			vncNextPort = offset + 1
+			return port
+		}
 	}
+	return 0
 }
 
+// StartVNC 端口已经用完时返回nil
 func StartVNC(width int, height int, taskName string) *VncInstance {
+	vncLock.Lock()
 	if i, ok := VncInstances[taskName]; ok {
+		vncLock.Unlock()
 		return i
 	}
-	atomic.AddUint64(&VncPortFrom, 1)
+	port := nextVncPort()
+	if port == 0 {
+		vncLock.Unlock()
+		LoggerStd.Warn("VNC port exhausted", zap.String("taskName", taskName), zap.Int("ports", VncPortCount))
+		return nil
+	}
 	s := &VncInstance{
 		TaskName: taskName,
-		Port:     (int)(VncPortFrom),
+		Port:     port,
 		State:    make(chan string, 1),
 	}
 	vncRoot := RootPath + "web/"
 	VncInstances[taskName] = s
+	metrics.VncSessions.Inc()
 	/*处理回调*/
 	cCallbacks := C.Callbacks{}
 	cCallbacks.mouse = C.MouseCallbackFn(C.mouseCallbackEvent)
@@ -102,13 +143,18 @@ func StartVNC(width int, height int, taskName string) *VncInstance {
 		C.CString(vncRoot),
 		cCallbacks)
 	s.Screen = uintptr(screen)
+	/*Screen创建后才能被RefreshVNC使用*/
+	vncLock.Unlock()
 	go func() {
 		for {
 			select {
 			case state := <-s.State:
 				if state == "WebClose" || state == "NoConnection" || state == "ScriptStop" {
 					C.StopVNC((C.ulong)(s.Screen))
+					vncLock.Lock()
 					delete(VncInstances, taskName)
+					vncLock.Unlock()
+					metrics.VncSessions.Dec()
 					LoggerStd.Info("VNC stop ", zap.String("taskName", taskName), zap.String("state", state))
 					return
 				}
@@ -124,7 +170,7 @@ func RefreshVNC(taskName string, img image.Image) {
 	w := VncWidth
 	h := VncHeight
 	newImage := resize.Resize(uint(w), 0, img, resize.Lanczos3)
-	if instance, ok := VncInstances[taskName]; ok {
+	if instance := findVNC(taskName); instance != nil {
 		buffer := make([]uint8, w*h*VncBPP)
 		var i, j int
 		for j = 0; j < h; j++ {
@@ -140,8 +186,12 @@ func RefreshVNC(taskName string, img image.Image) {
 }
 
 func StopVNC(taskName string, state string) {
-	if instance, ok := VncInstances[taskName]; ok {
-		instance.State <- state
+	if instance := findVNC(taskName); instance != nil {
+		/*已经有等待处理的停止请求时不再发送,避免阻塞*/
+		select {
+		case instance.State <- state:
+		default:
+		}
 	}
 }
 
@@ -0,0 +1,30 @@
+package common
+
+import "testing"
+
+func TestNextVncPort(t *testing.T) {
+	from, count := VncPortFrom, VncPortCount
+	defer func() {
+		VncPortFrom, VncPortCount, vncNextPort = from, count, 0
+	}()
+	VncPortFrom, VncPortCount, vncNextPort = 5000, 3, 0
+	vncLock.Lock()
+	defer vncLock.Unlock()
+	for _, want := range []int{5001, 5002, 5003} {
+		port := nextVncPort()
+		if port != want {
+			t.Fatalf("port %d, want %d", port, want)
+		}
+		VncInstances[string(rune('a'+port-5001))] = &VncInstance{Port: port}
+	}
+	if port := nextVncPort(); port != 0 {
+		t.Fatalf("exhausted port %d", port)
+	}
+	/*释放的端口重新使用*/
+	delete(VncInstances, "b")
+	if port := nextVncPort(); port != 5002 {
+		t.Fatalf("reuse port %d", port)
+	}
+	delete(VncInstances, "a")
+	delete(VncInstances, "c")
+}
@@ -6,6 +6,23 @@ import (
 	"os"
 )
 
+// AuthToken 调用merkaba接口的客户端凭证,token用于Bearer认证,secret用于HMAC签名
+type AuthToken struct {
+	Name   string   `yaml:"name" json:"name"`
+	Token  string   `yaml:"token" json:"token"`
+	Secret string   `yaml:"secret" json:"secret"`
+	Scopes []string `yaml:"scopes" json:"scopes"`
+}
+
+func (t *AuthToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == "admin" {
+			return true
+		}
+	}
+	return false
+}
+
 type YamlFile struct {
 	Environment struct {
 		DataCenter string `yaml:"dataCenter"`
@@ -21,6 +38,29 @@ type YamlFile struct {
 	}
 	Vnc struct {
 		AutoClose bool `yaml:"autoClose"`
+		PortFrom  int  `yaml:"portFrom"`
+		Ports     int  `yaml:"ports"`
+	}
+	Server struct {
+		Listen       string `yaml:"listen"`
+		GrpcPort     int    `yaml:"grpcPort"`
+		DrainTimeout int    `yaml:"drainTimeout"`
+		Journal      string `yaml:"journal"`
+	}
+	Queue struct {
+		Workers      int            `yaml:"workers"`
+		Refresh      int            `yaml:"refresh"`
+		MaxPending   int            `yaml:"maxPending"`
+		SiteLimit    int            `yaml:"siteLimit"`
+		AccountLimit int            `yaml:"accountLimit"`
+		Sites        map[string]int `yaml:"sites"`
+	}
+	Auth struct {
+		Enabled       bool        `yaml:"enabled"`
+		Consul        bool        `yaml:"consul"`
+		MaxSkew       int64       `yaml:"maxSkew"`
+		Tokens        []AuthToken `yaml:"tokens"`
+		PublicMetrics bool        `yaml:"publicMetrics"`
 	}
 	Database struct {
 		Mysql string `yaml:"mysql"`
@@ -36,6 +76,28 @@ type YamlFile struct {
 		Shot   string `yaml:"shot"`
 		Logger string `yaml:"logger"`
 	}
+	Script struct {
+		Store string `yaml:"store"`
+		Root  string `yaml:"root"`
+		Dir   string `yaml:"dir"`
+		Ref   string `yaml:"ref"`
+		Cache int    `yaml:"cache"`
+	}
+	Node struct {
+		Registry  string `yaml:"registry"`
+		Heartbeat int    `yaml:"heartbeat"`
+		Expire    int    `yaml:"expire"`
+	}
+	Evict struct {
+		Interval      int    `yaml:"interval"`
+		IdleTTL       int    `yaml:"idleTTL"`
+		MaxIdle       int    `yaml:"maxIdle"`
+		MinFreeMemory uint64 `yaml:"minFreeMemory"`
+	}
+	Artifact struct {
+		Root      string `yaml:"root"`
+		Retention int    `yaml:"retention"`
+	}
 	Consul struct {
 		Development []string `yaml:"development"`
 		Production  []string `yaml:"production"`
@@ -12,25 +12,83 @@ import (
 	"merkaba/goja/unistring"
 	"merkaba/rpc"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 )
 
 const hexUpper = "0123456789ABCDEF"
 
+/*按domain和taskName保存的浏览器,VM、终止请求和浏览器关闭的回调并发访问,需要持有webClientLock*/
 var (
-	WebClients = make(map[string]map[string]*chromedp.WebClient)
+	webClients    = make(map[string]map[string]*chromedp.WebClient)
+	webClientLock sync.Mutex
 )
 
+func FindWebClient(domain string, taskName string) *chromedp.WebClient {
+	webClientLock.Lock()
+	defer webClientLock.Unlock()
+	return webClients[domain][taskName]
+}
+
+func PutWebClient(domain string, taskName string, client *chromedp.WebClient) {
+	webClientLock.Lock()
+	defer webClientLock.Unlock()
+	webClientMap := webClients[domain]
+	if webClientMap == nil {
+		webClientMap = make(map[string]*chromedp.WebClient)
+		webClients[domain] = webClientMap
+	}
+	webClientMap[taskName] = client
+}
+
 func RemoveWebClient(domain string, taskName string) {
-	webClientMap := WebClients[domain]
+	webClientLock.Lock()
+	defer webClientLock.Unlock()
+	webClientMap := webClients[domain]
 	if webClientMap == nil {
 		return
 	}
 	delete(webClientMap, taskName)
+	if len(webClientMap) == 0 {
+		delete(webClients, domain)
+	}
+}
+
+// ReleaseWebClient 只删除仍然登记为client的浏览器,重试时上一个浏览器的关闭不影响新的浏览器
+func ReleaseWebClient(domain string, taskName string, client *chromedp.WebClient) {
+	webClientLock.Lock()
+	defer webClientLock.Unlock()
+	webClientMap := webClients[domain]
+	if webClientMap == nil || webClientMap[taskName] != client {
+		return
+	}
+	delete(webClientMap, taskName)
+	if len(webClientMap) == 0 {
+		delete(webClients, domain)
+	}
+}
+
+// CloseAllWebClients 关闭所有任务的浏览器,节点退出时调用
+func CloseAllWebClients() {
+	webClientLock.Lock()
+	clients := make([]*chromedp.WebClient, 0)
+	for _, webClientMap := range webClients {
+		for _, client := range webClientMap {
+			clients = append(clients, client)
+		}
+	}
+	webClients = make(map[string]map[string]*chromedp.WebClient)
+	webClientLock.Unlock()
+	/*Close会触发OnClientClose回调,不能持有锁*/
+	for _, client := range clients {
+		client.Close()
+	}
 }
 
 var (
@@ -521,18 +579,28 @@ func (r *Runtime) builtin_handleFile(call FunctionCall) Value {
 func (r *Runtime) builtin_wait(call FunctionCall) Value {
 	v := call.Argument(0).ToInteger()
 	r.Context.Info("wait", zap.Int64("timeout", v))
-	time.Sleep(time.Duration(v) * time.Second)
+	r.sleep(time.Duration(v) * time.Second)
 	return nil
 }
 
+/*Interrupt不能中断native函数,等待期间定期检查,被终止后提前返回*/
+func (r *Runtime) sleep(d time.Duration) {
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if atomic.LoadUint32(&r.vm.interrupted) != 0 {
+			return
+		}
+		step := time.Until(deadline)
+		if step > 100*time.Millisecond {
+			step = 100 * time.Millisecond
+		}
+		time.Sleep(step)
+	}
+}
+
 func (r *Runtime) builtin_webClient(call FunctionCall) Value {
 	domain := call.Argument(0).String()
-	webClientMap := WebClients[domain]
-	if webClientMap == nil {
-		webClientMap = make(map[string]*chromedp.WebClient)
-		WebClients[domain] = webClientMap
-	}
-	webClient := webClientMap[r.Context.TaskName]
+	webClient := FindWebClient(domain, r.Context.TaskName)
 	if webClient != nil {
 		r.WebClient = webClient
 		return r.CreateWebClientObject(webClient)
@@ -543,7 +611,7 @@ func (r *Runtime) builtin_webClient(call FunctionCall) Value {
 	}
 	option := chromedp.WebClientOption{Domain: domain, Proxy: r.Context.Proxy, Headless: r.Context.Headless}
 	webClient = chromedp.NewClient(option, r.Context)
-	webClientMap[r.Context.TaskName] = webClient
+	PutWebClient(domain, r.Context.TaskName, webClient)
 	r.WebClient = webClient
 	webClient.ScriptHandler = r.ScriptHandler
 	o := r.CreateWebClientObject(webClient)
@@ -763,14 +831,25 @@ func (r *Runtime) builtin_breakPoint(call FunctionCall) Value {
 func (r *Runtime) builtin_downFile(call FunctionCall) Value {
 	url := call.Argument(0).String()
 	fileName := call.Argument(1).String()
-	fullFileName := common.Env.Path.Temp + fileName
+	runId := r.runId()
+	fullFileName := common.ArtifactDir(runId) + fileName
+	os.MkdirAll(filepath.Dir(fullFileName), 0755)
 	err := common.HttpGetFile(url, make(map[string]interface{}), fullFileName)
 	if err != nil {
 		return nil
 	}
+	common.RecordArtifact(runId, common.ArtifactDownload, fullFileName)
 	return r.ToValue(fullFileName)
 }
 
+/*脚本产生的文件保存到本次运行的产物目录,没有运行上下文时为空*/
+func (r *Runtime) runId() string {
+	if r.Context == nil {
+		return ""
+	}
+	return r.Context.RunId
+}
+
 func (r *Runtime) builtin_writeFile(call FunctionCall) Value {
 	fileName := call.Argument(0).String()
 	names := strings.Split(fileName, ".")
@@ -784,7 +863,9 @@ func (r *Runtime) builtin_writeFile(call FunctionCall) Value {
 		fileExt = ".txt"
 	}
 	content := call.Argument(1).String()
-	fullFileName := common.Env.Path.Temp + fileName + "." + fileExt
+	runId := r.runId()
+	fullFileName := common.ArtifactDir(runId) + fileName + "." + fileExt
+	os.MkdirAll(filepath.Dir(fullFileName), 0755)
 	f, err := os.Create(fullFileName)
 	if err != nil {
 		return nil
@@ -795,6 +876,7 @@ func (r *Runtime) builtin_writeFile(call FunctionCall) Value {
 	}
 	f.WriteString(content)
 	f.Close()
+	common.RecordArtifact(runId, common.ArtifactFile, fullFileName)
 	return r.ToValue(fullFileName)
 }
 
@@ -34,6 +34,7 @@ type Registry struct {
 	sync.Mutex
 	native   map[string]ModuleLoader
 	compiled map[string]*Program
+	programs *ProgramCache
 
 	srcLoader     SourceLoader
 	globalFolders []string
@@ -144,11 +145,18 @@ func (r *Registry) getCompiledSource(p string) (*Program, error) {
 		}
 
 		source := "(function(exports, require, module) {" + s + "\n})"
-		parsed, err := Parse(p, source, parser.WithSourceMapLoader(r.srcLoader))
-		if err != nil {
-			return nil, err
+		/*模块没有版本,按内容hash使用节点共享的编译结果*/
+		programs := r.programs
+		if programs == nil {
+			programs = Programs
 		}
-		prg, err = CompileAST(parsed, false)
+		prg, err = programs.Get(p, "", source, func() (*Program, error) {
+			parsed, err := Parse(p, source, parser.WithSourceMapLoader(r.srcLoader))
+			if err != nil {
+				return nil, err
+			}
+			return CompileAST(parsed, false)
+		})
 		if err == nil {
 			if r.compiled == nil {
 				r.compiled = make(map[string]*Program)
@@ -160,6 +168,13 @@ func (r *Registry) getCompiledSource(p string) (*Program, error) {
 	return prg, nil
 }
 
+/*模块编译结果使用的缓存,默认是Programs*/
+func (r *Registry) usePrograms(programs *ProgramCache) {
+	r.Lock()
+	defer r.Unlock()
+	r.programs = programs
+}
+
 func (r *RequireModule) require(call FunctionCall) Value {
 	ret, err := r.Require(call.Argument(0).String())
 	if err != nil {
@@ -208,6 +223,7 @@ func (r *RequireModule) clear() {
 	r.modules = make(map[string]*Object)
 }
 
+// clear 每次执行后重新读取模块源码,内容没有变化的模块不需要重新编译
 func (r *Registry) clear() {
 	r.compiled = make(map[string]*Program)
 }
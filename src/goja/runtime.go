@@ -1494,6 +1494,11 @@ func (r *Runtime) Interrupt(v interface{}) {
 	r.vm.Interrupt(v)
 }
 
+// SetPauseGate 设置暂停点,暂停后VM在下一条指令之前阻塞,直到恢复
+func (r *Runtime) SetPauseGate(gate *common.PauseGate) {
+	r.vm.pauseGate = gate
+}
+
 // ClearInterrupt resets the interrupt flag. Typically this needs to be called before the runtime
 // is made available for re-use if there is a chance it could have been interrupted with Interrupt().
 // Otherwise if Interrupt() was called when runtime was not running (e.g. if it had already finished)
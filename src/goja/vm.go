@@ -11,6 +11,7 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"merkaba/common"
 	"merkaba/goja/unistring"
 )
 
@@ -267,6 +268,8 @@ type vm struct {
 	interrupted   uint32
 	interruptVal  interface{}
 	interruptLock sync.Mutex
+	/*暂停时在下一条指令之前阻塞*/
+	pauseGate *common.PauseGate
 
 	debugger  *Debugger
 	debugMode bool // TODO drop this as we can just check debugger is nil or not
@@ -494,6 +497,9 @@ func (vm *vm) run() {
 	interrupted := false
 	ticks := 0
 	for !vm.halt {
+		if vm.pauseGate != nil && vm.pauseGate.Paused() {
+			vm.pauseGate.Wait()
+		}
 		if interrupted = atomic.LoadUint32(&vm.interrupted) != 0; interrupted {
 			break
 		}
@@ -525,6 +531,9 @@ func (vm *vm) debug() {
 	// vm.debugger.activate(ProgramStartActivation)
 
 	for !vm.halt {
+		if vm.pauseGate != nil && vm.pauseGate.Paused() {
+			vm.pauseGate.Wait()
+		}
 		if interrupted = atomic.LoadUint32(&vm.interrupted) != 0; interrupted {
 			break
 		}
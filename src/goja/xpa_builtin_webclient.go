@@ -14,19 +14,14 @@ type webClientObject struct {
 func (mo *webClientObject) init(r *Runtime, args []Value) {
 	mo.baseObject.init()
 	domain := args[0].String()
-	webClientMap := WebClients[domain]
-	if webClientMap == nil {
-		webClientMap = make(map[string]*chromedp.WebClient)
-		WebClients[domain] = webClientMap
-	}
-	webClient := webClientMap[r.Context.TaskName]
+	webClient := FindWebClient(domain, r.Context.TaskName)
 	if webClient != nil {
 		mo.m = webClient
 		r.WebClient = webClient
 	} else {
 		option := chromedp.WebClientOption{Domain: domain, Proxy: r.Context.Proxy, Headless: r.Context.Headless}
 		webClient = chromedp.NewClient(option, r.Context)
-		webClientMap[r.Context.TaskName] = webClient
+		PutWebClient(domain, r.Context.TaskName, webClient)
 		r.WebClient = webClient
 		mo.m = webClient
 	}
@@ -0,0 +1,87 @@
+package goja
+
+import (
+	"go.uber.org/zap"
+	"merkaba/common"
+	"merkaba/common/metrics"
+	"sort"
+	"time"
+)
+
+/*回收空闲实例的原因*/
+const (
+	EvictIdleTTL = "idleTTL"
+	EvictMaxIdle = "maxIdle"
+	EvictMemory  = "memory"
+	EvictOneShot = "oneShot" /*只执行一次的实例,执行结束后回收*/
+)
+
+// EvictPolicy 空闲实例的回收策略,0表示不限制
+type EvictPolicy struct {
+	IdleTTL time.Duration // 空闲超过的时间
+	MaxIdle int           // 最多保留的空闲实例
+}
+
+// IdleInstances 空闲的实例,最久没有使用的在前面
+func (db *ScriptDb) IdleInstances() []*ScriptInstance {
+	result := make([]*ScriptInstance, 0)
+	for _, i := range db.Instances.All() {
+		if i.Status() == InstanceIdle {
+			result = append(result, i)
+		}
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].IdleSince() < result[b].IdleSince()
+	})
+	return result
+}
+
+// EvictMemInstance 回收空闲的实例:从内存中删除,关闭浏览器;实例已经被使用时返回false
+func (db *ScriptDb) EvictMemInstance(instance *ScriptInstance, reason string) bool {
+	if !instance.compareAndSwapStatus(InstanceIdle, InstanceEvicting) {
+		return false
+	}
+	db.Instances.Remove(instance)
+	if instance.RunVM != nil {
+		instance.RunVM.CloseWebClients()
+	}
+	metrics.Evictions.WithLabelValues(reason).Inc()
+	if db.OnEvict != nil {
+		db.OnEvict()
+	}
+	common.LoggerStd.Info("evict idle instance", zap.String("taskName", instance.Context.TaskName),
+		zap.String("reason", reason), zap.Int64("idleSince", instance.IdleSince()))
+	return true
+}
+
+// Evict 回收空闲超时的实例,以及超过MaxIdle的最久没有使用的实例,返回回收的数量
+func (db *ScriptDb) Evict(policy EvictPolicy) int {
+	idle := db.IdleInstances()
+	now := time.Now().UnixMilli()
+	count := 0
+	for i, instance := range idle {
+		reason := ""
+		if policy.IdleTTL > 0 && now-instance.IdleSince() >= policy.IdleTTL.Milliseconds() {
+			reason = EvictIdleTTL
+		} else if policy.MaxIdle > 0 && len(idle)-i > policy.MaxIdle {
+			reason = EvictMaxIdle
+		} else {
+			/*按空闲时间排序,后面的都不需要回收*/
+			break
+		}
+		if db.EvictMemInstance(instance, reason) {
+			count += 1
+		}
+	}
+	return count
+}
+
+// EvictOldest 内存不足时回收最久没有使用的实例,没有空闲实例时返回false
+func (db *ScriptDb) EvictOldest() bool {
+	for _, instance := range db.IdleInstances() {
+		if db.EvictMemInstance(instance, EvictMemory) {
+			return true
+		}
+	}
+	return false
+}
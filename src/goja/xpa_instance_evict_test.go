@@ -0,0 +1,67 @@
+package goja
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"testing"
+	"time"
+)
+
+/*按idle的顺序登记空闲实例,idle是距离现在的空闲时间*/
+func evictTestDb(idle ...time.Duration) (*ScriptDb, []*ScriptInstance) {
+	common.LoggerStd = zap.NewNop()
+	db := &ScriptDb{Instances: NewInstanceRegistry()}
+	instances := make([]*ScriptInstance, 0, len(idle))
+	for i, d := range idle {
+		instance := &ScriptInstance{
+			Context: &common.RunContext{TaskName: fmt.Sprintf("task%d", i), ScriptUri: "jd.com/login"},
+			DB:      db,
+		}
+		instance.idleSince.Store(time.Now().Add(-d).UnixMilli())
+		db.AddMemInstance(instance)
+		instances = append(instances, instance)
+	}
+	return db, instances
+}
+
+func TestEvict(t *testing.T) {
+	db, instances := evictTestDb(time.Hour, time.Minute, 5*time.Minute, time.Second)
+	/*正在运行的实例不回收*/
+	instances[0].status.Store(InstanceRunning)
+	reported := 0
+	db.OnEvict = func() { reported++ }
+	count := db.Evict(EvictPolicy{IdleTTL: 2 * time.Minute, MaxIdle: 1})
+	/*task2超时,task1超过MaxIdle,保留最近使用的task3*/
+	if count != 2 || reported != 2 {
+		t.Fatalf("evicted %d, reported %d", count, reported)
+	}
+	for i, want := range []bool{true, false, false, true} {
+		if exist := db.FindMemInstance(instances[i].Context.TaskName) != nil; exist != want {
+			t.Fatalf("task%d exist=%v", i, exist)
+		}
+	}
+	if instances[2].Status() != InstanceEvicting || instances[1].Status() != InstanceEvicting {
+		t.Fatal("evicted instances are marked")
+	}
+	if db.Evict(EvictPolicy{}) != 0 {
+		t.Fatal("zero policy evicts nothing")
+	}
+}
+
+func TestEvictOldest(t *testing.T) {
+	db, instances := evictTestDb(time.Minute, time.Hour)
+	if !db.EvictOldest() || db.FindMemInstance(instances[1].Context.TaskName) != nil {
+		t.Fatal("oldest idle instance first")
+	}
+	/*等待执行的实例不能回收*/
+	if !db.QueueMemInstance(instances[0]) {
+		t.Fatal("queue")
+	}
+	if db.EvictOldest() {
+		t.Fatal("no idle instance")
+	}
+	if db.EvictMemInstance(instances[0], EvictMemory) || instances[0].Status() != InstanceQueued {
+		t.Fatal("queued instance evicted")
+	}
+}
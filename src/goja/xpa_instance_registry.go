@@ -0,0 +1,100 @@
+package goja
+
+import (
+	"sync"
+	"time"
+)
+
+/*实例的状态*/
+const (
+	InstanceIdle     = "Idle"
+	InstanceRunning  = "Running"
+	InstanceQueued   = "Queued"
+	InstanceEvicting = "Evicting" /*正在回收,不能再使用*/
+)
+
+// InstanceRegistry 内存中的实例,按taskName和scriptUri索引,http请求、队列的worker和浏览器关闭的回调并发访问
+type InstanceRegistry struct {
+	lock   sync.RWMutex
+	byTask map[string]*ScriptInstance
+	byUri  map[string]map[string]*ScriptInstance
+}
+
+func NewInstanceRegistry() *InstanceRegistry {
+	return &InstanceRegistry{
+		byTask: make(map[string]*ScriptInstance),
+		byUri:  make(map[string]map[string]*ScriptInstance),
+	}
+}
+
+// Add 登记实例,taskName已经存在时返回已有的实例和false
+func (r *InstanceRegistry) Add(instance *ScriptInstance) (*ScriptInstance, bool) {
+	taskName := instance.Context.TaskName
+	uri := instance.Context.ScriptUri
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if exist, ok := r.byTask[taskName]; ok {
+		return exist, false
+	}
+	r.byTask[taskName] = instance
+	instance.idleSince.CompareAndSwap(0, time.Now().UnixMilli())
+	tasks := r.byUri[uri]
+	if tasks == nil {
+		tasks = make(map[string]*ScriptInstance)
+		r.byUri[uri] = tasks
+	}
+	tasks[taskName] = instance
+	return instance, true
+}
+
+// Remove 删除实例,taskName已经被其他实例使用时不删除
+func (r *InstanceRegistry) Remove(instance *ScriptInstance) {
+	taskName := instance.Context.TaskName
+	uri := instance.Context.ScriptUri
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.byTask[taskName] != instance {
+		return
+	}
+	delete(r.byTask, taskName)
+	if tasks := r.byUri[uri]; tasks != nil {
+		delete(tasks, taskName)
+		if len(tasks) == 0 {
+			delete(r.byUri, uri)
+		}
+	}
+}
+
+func (r *InstanceRegistry) Find(taskName string) *ScriptInstance {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.byTask[taskName]
+}
+
+// FindByUri 同一个脚本的所有实例
+func (r *InstanceRegistry) FindByUri(scriptUri string) []*ScriptInstance {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	result := make([]*ScriptInstance, 0, len(r.byUri[scriptUri]))
+	for _, instance := range r.byUri[scriptUri] {
+		result = append(result, instance)
+	}
+	return result
+}
+
+// All 所有实例的快照,遍历时不持有锁
+func (r *InstanceRegistry) All() []*ScriptInstance {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	result := make([]*ScriptInstance, 0, len(r.byTask))
+	for _, instance := range r.byTask {
+		result = append(result, instance)
+	}
+	return result
+}
+
+func (r *InstanceRegistry) Len() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return len(r.byTask)
+}
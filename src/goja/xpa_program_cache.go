@@ -0,0 +1,106 @@
+package goja
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"merkaba/common/metrics"
+	"sync"
+)
+
+const (
+	defaultProgramCacheSize       = 512
+	defaultInlineProgramCacheSize = 32
+)
+
+/*runInline提交的脚本没有保存在数据库,记录为这个版本*/
+const InlineScriptVersion = "inline"
+
+// Programs 节点内所有实例共享的编译结果
+var Programs = NewProgramCache(defaultProgramCacheSize)
+
+// InlinePrograms runInline的脚本单独缓存,临时执行的脚本不会淘汰Programs中的脚本
+var InlinePrograms = NewProgramCache(defaultInlineProgramCacheSize)
+
+type programKey struct {
+	uri     string
+	version string
+	hash    string
+}
+
+type programItem struct {
+	key     programKey
+	program *Program
+}
+
+// ProgramCache 编译后的脚本和模块,按uri、版本和内容hash索引,超过容量时淘汰最久没有使用的;
+// 内容变化后hash不同,只有变化的模块需要重新编译
+type ProgramCache struct {
+	lock     sync.Mutex
+	capacity int
+	items    map[programKey]*list.Element
+	order    *list.List
+}
+
+func NewProgramCache(capacity int) *ProgramCache {
+	return &ProgramCache{
+		capacity: capacity,
+		items:    make(map[programKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func contentHash(content string) string {
+	sum := sha1.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get 返回缓存的编译结果,没有时调用compile编译;编译失败的不缓存
+func (c *ProgramCache) Get(uri string, version string, content string, compile func() (*Program, error)) (*Program, error) {
+	key := programKey{uri: uri, version: version, hash: contentHash(content)}
+	c.lock.Lock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		c.lock.Unlock()
+		metrics.ProgramCache.WithLabelValues("hit").Inc()
+		return e.Value.(*programItem).program, nil
+	}
+	c.lock.Unlock()
+	/*编译不持有锁,并发编译同一个脚本时后放入的覆盖先放入的*/
+	metrics.ProgramCache.WithLabelValues("miss").Inc()
+	program, err := compile()
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*programItem).program, nil
+	}
+	c.items[key] = c.order.PushFront(&programItem{key: key, program: program})
+	c.evict()
+	return program, nil
+}
+
+func (c *ProgramCache) SetCapacity(capacity int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.capacity = capacity
+	c.evict()
+}
+
+func (c *ProgramCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.items)
+}
+
+func (c *ProgramCache) evict() {
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*programItem).key)
+		metrics.ProgramCache.WithLabelValues("evict").Inc()
+	}
+}
@@ -0,0 +1,56 @@
+package goja
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProgramCache(t *testing.T) {
+	c := NewProgramCache(2)
+	compiles := 0
+	get := func(uri string, content string) *Program {
+		p, err := c.Get(uri, "v1", content, func() (*Program, error) {
+			compiles++
+			return &Program{}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	a := get("a", "1")
+	if get("a", "1") != a || compiles != 1 {
+		t.Fatalf("hit: compiles=%d", compiles)
+	}
+	/*内容变化后重新编译*/
+	if get("a", "2") == a || compiles != 2 {
+		t.Fatalf("changed content: compiles=%d", compiles)
+	}
+	/*a:1最久没有使用,被淘汰*/
+	get("b", "1")
+	if c.Len() != 2 {
+		t.Fatalf("len %d", c.Len())
+	}
+	get("a", "1")
+	if compiles != 4 {
+		t.Fatalf("evicted: compiles=%d", compiles)
+	}
+	c.SetCapacity(1)
+	if c.Len() != 1 {
+		t.Fatalf("shrink: len %d", c.Len())
+	}
+	if get("a", "1") != get("a", "1") || compiles != 4 {
+		t.Fatalf("most recent kept: compiles=%d", compiles)
+	}
+}
+
+func TestProgramCacheCompileError(t *testing.T) {
+	c := NewProgramCache(2)
+	fail := errors.New("SyntaxError")
+	if _, err := c.Get("a", "v1", "x(", func() (*Program, error) { return nil, fail }); err != fail {
+		t.Fatalf("err %v", err)
+	}
+	if c.Len() != 0 {
+		t.Fatal("failed compile is not cached")
+	}
+}
@@ -1,141 +1,172 @@
 package goja
 
 import (
+	dbsql "database/sql"
+	"errors"
 	"go.uber.org/zap"
 	"merkaba/common"
-	"runtime"
 	"time"
 )
 
+/*merkaba_node.state*/
+const (
+	NodeStateUp       = common.NodeStateUp
+	NodeStateDown     = common.NodeStateDown
+	NodeStateDraining = common.NodeStateDraining
+)
+
 type ScriptDb struct {
 	Client    *common.MysqlClient
-	Instances []*ScriptInstance
+	Store     common.ScriptStore
+	Nodes     common.NodeRegistry
+	Instances *InstanceRegistry
+	Journal   RunJournal
+	OnEvict   func() /*回收实例后调用,立即上报节点的实例数量*/
 }
 
-type MerkabaNode struct {
-	IP       string `db:"ip"`
-	Port     string `db:"port"`
-	MaxCount int    `db:"maxCount"`
+// RunJournal 记录实例的执行状态,节点重启后恢复没有结束的执行
+type RunJournal interface {
+	Started(s *ScriptInstance)
+	Finished(runId string)
 }
 
-type ScriptInfo struct {
-	Content string `db:"content"`
-	Version string `db:"version"`
+type MerkabaNode struct {
+	IP           string `db:"ip"`
+	Port         string `db:"port"`
+	MaxCount     int    `db:"maxCount"`
+	SiteLimit    int    `db:"siteLimit"`
+	AccountLimit int    `db:"accountLimit"`
 }
 
 func (db *ScriptDb) Init() {
-	db.Instances = make([]*ScriptInstance, 0)
+	db.Instances = NewInstanceRegistry()
+	if common.Env.Script.Cache > 0 {
+		Programs.SetCapacity(common.Env.Script.Cache)
+	}
+	if db.Store == nil {
+		db.Store = &common.MysqlScriptStore{Client: db.Client, Production: common.Env.Environment.Production}
+	}
+	if db.Nodes == nil {
+		db.Nodes = &common.MysqlNodeRegistry{Client: db.Client}
+	}
 }
 
 func (db *ScriptDb) ReadScriptByUri(uri string) (content string, version string) {
-	var id []string
-	db.Client.Select(&id, "select id from merkaba where uri=?", uri)
-	if len(id) == 0 {
-		return "", ""
-	}
-	return db.ReadScript(id[0])
+	return common.ReadScriptByUri(db.Store, uri)
+}
+
+// ReadScriptId 根据uri查找脚本的id,不存在时返回空
+func (db *ScriptDb) ReadScriptId(uri string) string {
+	return db.Store.ReadScriptId(uri)
 }
 
 func (db *ScriptDb) ReadScript(id string) (content string, version string) {
-	var sql string
-	if common.Env.Environment.Production {
-		sql = `select content,version from merkaba_script_prd where id=?`
-	} else {
-		sql = `select content,version from merkaba_script_dev where id=?`
-	}
-	var result ScriptInfo
-	var err = db.Client.Get(&result, sql, id)
-	if err != nil {
-		common.LoggerStd.Error(sql, zap.Error(err))
-		return "", ""
-	}
-	return result.Content, result.Version
+	return db.Store.ReadScript(id)
 }
 
-func (db *ScriptDb) RegisterMerkabaNode() {
-	sql := `
-            insert into merkaba_node(ip,name,port,platform,createdTime,state) values(?,?,?,?,?,?)
-            on duplicate key update 
-                name = values(name),port = values(port),platform = values(platform),
-                createdTime=values(createdTime),state=values(state)                             
-	`
-	var _, err = db.Client.Update(sql, common.LocalIP, common.LocalName, common.LocalPort, runtime.GOOS, time.Now().UnixMilli(), 4)
-	if err != nil {
-		common.LoggerStd.Error(sql, zap.Error(err))
-		return
-	}
+func (db *ScriptDb) nodeLoad(state int) *common.NodeLoad {
+	runCount, idleCount := db.ReadInstanceCount()
+	return common.ReadNodeLoad(state, runCount, idleCount)
+}
+
+func (db *ScriptDb) RegisterMerkabaNode() error {
+	return db.Nodes.Register(db.nodeLoad(NodeStateUp))
+}
+
+// HeartbeatMerkabaNode 上报本节点的状态和负载
+func (db *ScriptDb) HeartbeatMerkabaNode(state int) error {
+	return db.Nodes.Heartbeat(db.nodeLoad(state))
+}
+
+// ExpireMerkabaNodes 心跳超时的节点标记为下线
+func (db *ScriptDb) ExpireMerkabaNodes() (int, error) {
+	return db.Nodes.Expire(common.NodeExpire())
 }
 
 func (db *ScriptDb) UnRegisterMerkabaNode() {
+	db.Nodes.SetState(NodeStateDown)
+}
+
+// DrainMerkabaNode 节点准备下线,不再分配新的任务
+func (db *ScriptDb) DrainMerkabaNode() {
+	db.Nodes.SetState(NodeStateDraining)
+}
+
+// UpdateMaxCount 修改本节点的最大实例数,同时也是执行队列的worker数量
+func (db *ScriptDb) UpdateMaxCount(maxCount int) error {
 	sql := `
-            update merkaba_node set state=5 where ip=?                             
+            update merkaba_node set maxCount=? where ip=?
 	`
-	var _, err = db.Client.Update(sql, common.LocalIP)
+	var _, err = db.Client.Update(sql, maxCount, common.LocalIP)
 	if err != nil {
 		common.LoggerStd.Error(sql, zap.Error(err))
-		return
 	}
+	return err
 }
 
-func (db *ScriptDb) ReadLocalMerkabaNode() *MerkabaNode {
+// ReadLocalMerkabaNode 本节点在merkaba_node中的配置,没有登记时返回空的配置
+func (db *ScriptDb) ReadLocalMerkabaNode() (*MerkabaNode, error) {
 	sql := `
-            select ip,maxCount from merkaba_node where ip=?                            
+            select ip,maxCount,siteLimit,accountLimit from merkaba_node where ip=?
 	`
 	var result MerkabaNode
 	var err = db.Client.Get(&result, sql, common.LocalIP)
+	if errors.Is(err, dbsql.ErrNoRows) {
+		return &result, nil
+	}
 	if err != nil {
 		common.LoggerStd.Error(sql, zap.Error(err))
+		return nil, err
 	}
-	return &result
+	return &result, nil
 }
 
 func (db *ScriptDb) FindMemInstance(taskName string) *ScriptInstance {
-	for i := 0; i < len(db.Instances); i++ {
-		instance := db.Instances[i]
-		if instance.Context.TaskName == taskName {
-			return instance
-		}
-	}
-	return nil
+	return db.Instances.Find(taskName)
 }
 
 func (db *ScriptDb) InstanceCount() int {
-	return len(db.Instances)
+	return db.Instances.Len()
 }
 
 // ExistMemInstance 0=NotExist, Idle, Running */
 func (db *ScriptDb) ExistMemInstance(scriptUri string, taskName string) int {
-	for i := 0; i < len(db.Instances); i++ {
-		instance := db.Instances[i]
-		if instance.Context.ScriptUri == scriptUri && instance.Context.TaskName == taskName {
-			if instance.Status == "Running" {
-				return 2
-			} else {
-				return 1
-			}
-		}
+	instance := db.Instances.Find(taskName)
+	if instance == nil || instance.Context.ScriptUri != scriptUri {
+		return 0
+	}
+	if instance.Busy() {
+		return 2
 	}
-	return 0
+	return 1
 }
 
-func (db *ScriptDb) AddMemInstance(instance *ScriptInstance) {
-	db.Instances = append(db.Instances, instance)
+// AddMemInstance 登记新的实例,taskName已经存在时返回已有的实例
+func (db *ScriptDb) AddMemInstance(instance *ScriptInstance) (*ScriptInstance, bool) {
+	return db.Instances.Add(instance)
 }
 
 func (db *ScriptDb) RemoveMemInstance(instance *ScriptInstance) {
-	for i := 0; i < len(db.Instances); i++ {
-		if db.Instances[i] == instance {
-			db.Instances = append(db.Instances[:i], db.Instances[i+1:]...)
-			i--
+	db.Instances.Remove(instance)
+}
+
+// RunningInstances 返回正在运行或者等待运行的实例
+func (db *ScriptDb) RunningInstances() []*ScriptInstance {
+	result := make([]*ScriptInstance, 0)
+	for _, i := range db.Instances.All() {
+		if i.Busy() {
+			result = append(result, i)
 		}
 	}
+	return result
 }
 
 func (db *ScriptDb) ReadInstanceCount() (runCount int, idleCount int) {
 	runCount = 0
 	idleCount = 0
-	for _, i := range db.Instances {
-		if i.Status == "Running" {
+	for _, i := range db.Instances.All() {
+		/*等待执行的实例已经占用了VM*/
+		if i.Busy() {
 			runCount += 1
 		} else {
 			idleCount += 1
@@ -144,21 +175,35 @@ func (db *ScriptDb) ReadInstanceCount() (runCount int, idleCount int) {
 	return runCount, idleCount
 }
 
-func (db *ScriptDb) updateNodeStatus() (runCount int, idleCount int) {
+func (db *ScriptDb) UseMemInstance(instance *ScriptInstance) (runCount int, idleCount int) {
+	instance.status.Store(InstanceRunning)
 	runCount, idleCount = db.ReadInstanceCount()
-	sql := "update merkaba_node set runCount=?,idleCount=? where ip=?"
-	db.Client.Update(sql, runCount, idleCount, common.LocalIP)
 	return runCount, idleCount
 }
 
-func (db *ScriptDb) UseMemInstance(instance *ScriptInstance) (runCount int, idleCount int) {
-	instance.Status = "Running"
-	runCount, idleCount = db.updateNodeStatus()
-	return runCount, idleCount
+// QueueMemInstance 空闲的实例转为等待执行,实例正在运行、已经在队列中或者上一次执行还没有结束时返回false
+func (db *ScriptDb) QueueMemInstance(instance *ScriptInstance) bool {
+	if !instance.compareAndSwapStatus(InstanceIdle, InstanceQueued) {
+		return false
+	}
+	/*转为等待执行后才能读取done,只有持有Queued状态的调用方会替换它*/
+	if !instance.runDone() {
+		instance.status.Store(InstanceIdle)
+		return false
+	}
+	return true
 }
 
 func (db *ScriptDb) FreeMemInstance(instance *ScriptInstance) (runCount int, idleCount int) {
-	instance.Status = "Idle"
-	runCount, idleCount = db.updateNodeStatus()
+	instance.status.Store(InstanceIdle)
+	instance.idleSince.Store(time.Now().UnixMilli())
+	runCount, idleCount = db.ReadInstanceCount()
 	return runCount, idleCount
 }
+
+// finishMemInstance 执行结束,运行中的实例转为空闲;只在Run退出时调用
+func (db *ScriptDb) finishMemInstance(instance *ScriptInstance) {
+	if instance.compareAndSwapStatus(InstanceRunning, InstanceIdle) {
+		instance.idleSince.Store(time.Now().UnixMilli())
+	}
+}
@@ -1,26 +1,71 @@
 package goja
 
 import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 	"merkaba/chromedp"
 	"merkaba/common"
+	"merkaba/common/metrics"
+	"merkaba/common/queue"
 	"merkaba/rpc"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type ScriptInstance struct {
 	Context       *common.RunContext
 	ScriptContent string
+	Modules       map[string]string
+	QueuedTime    int64
 	StartTime     int64
 	StopTime      int64
 	IsSuccess     bool
 	Variables     []string
 	BreakPoints   []map[string]interface{}
-	Status        string
+	Priority      int
+	Retry         *RetryPolicy
+	Attempt       int
+	MaxRunTime    int64 /*整个执行(包括重试)的最长时间,单位秒,0表示不限制*/
 	ErrorMessage  string
 	DB            *ScriptDb
 	RunVM         *ScriptVM
 	fnTimeout     func(any) (bool, error)
+	done          chan struct{}
+	/*Interrupt时取消,结束重试前的等待*/
+	stopped  chan struct{}
+	stopOnce *sync.Once
+	timedOut atomic.Bool
+	status   atomic.Value
+	/*最近一次转为空闲的时间,回收时按它排序*/
+	idleSince atomic.Int64
+}
+
+func (s *ScriptInstance) IdleSince() int64 {
+	return s.idleSince.Load()
+}
+
+// Status Idle, Running 或者 Queued
+func (s *ScriptInstance) Status() string {
+	if v, ok := s.status.Load().(string); ok {
+		return v
+	}
+	return InstanceIdle
+}
+
+// Busy 正在运行或者等待执行
+func (s *ScriptInstance) Busy() bool {
+	status := s.Status()
+	return status == InstanceRunning || status == InstanceQueued
+}
+
+func (s *ScriptInstance) compareAndSwapStatus(from string, to string) bool {
+	if from == InstanceIdle {
+		/*新建的实例还没有设置过状态*/
+		s.status.CompareAndSwap(nil, InstanceIdle)
+	}
+	return s.status.CompareAndSwap(from, to)
 }
 
 // HandleTimeout  如果返回为true,则退出timeout函数，否则一直判断
@@ -29,6 +74,7 @@ func (s *ScriptInstance) HandleTimeout(webPage any, err error) (bool, error) {
 	if s.fnTimeout == nil {
 		return true, err
 	}
+	metrics.HandleTimeouts.WithLabelValues(s.Context.ScriptUri).Inc()
 	page := vm.CreateWebPageObject(webPage.(*chromedp.WebPage))
 	result, _err := s.fnTimeout(page)
 	if _err != nil {
@@ -37,16 +83,24 @@ func (s *ScriptInstance) HandleTimeout(webPage any, err error) (bool, error) {
 	return result, err
 }
 
+// InitVM 创建新的VM,初始化完成后才替换RunVM,终止和超时不会用到没有初始化的VM
 func (s *ScriptInstance) InitVM() error {
-	s.RunVM = &ScriptVM{}
+	vm := &ScriptVM{}
 	s.Context.OnClientClose = s.OnClientClose
-	return s.RunVM.Init(s)
+	if err := vm.Init(s); err != nil {
+		return err
+	}
+	s.RunVM = vm
+	return nil
 }
 
+// OnClientClose 浏览器关闭,空闲的实例同时删除;运行中的实例(终止,超时,重试)由Run结束后释放
 func (s *ScriptInstance) OnClientClose(ev interface{}) {
 	if client, ok := ev.(*chromedp.WebClient); ok {
-		delete(WebClients, client.Option.Domain)
-		s.DB.RemoveMemInstance(s)
+		ReleaseWebClient(client.Option.Domain, s.Context.TaskName, client)
+		if !s.Busy() {
+			s.DB.RemoveMemInstance(s)
+		}
 	}
 }
 
@@ -57,15 +111,142 @@ func (s *ScriptInstance) Run() {
 	fields = append(fields, zap.String("uri", s.Context.ScriptUri))
 
 	s.DB.UseMemInstance(s)
+	if len(s.Context.RunId) == 0 {
+		s.newRun("")
+	}
+	/*这次执行的通道和runId,实例转为空闲后可能被新的执行替换*/
+	runId := s.Context.RunId
+	done := s.done
+	stopped := s.stopped
+	stopOnce := s.stopOnce
+	fields = append(fields, zap.String("runId", runId))
 	/*数据发回监控中心，更新实例状态*/
-	s.StopTime = time.Now().UnixMilli()
-	vm := s.RunVM.Runtime
+	s.StartTime = time.Now().UnixMilli()
+	s.StopTime = 0
+	s.ErrorMessage = ""
+	/*上一次执行结束前的暂停不带到这次执行*/
+	s.Context.Pause.Resume()
+	record := newRunRecord(s)
+	s.DB.InsertRun(record)
+	if s.DB.Journal != nil {
+		s.DB.Journal.Started(s)
+	}
 	msg := "===>start script"
 	s.Context.Info(msg, fields...)
+	common.PublishState(s.Context, common.StateStarted, msg)
 	s.remoteCall("Merkaba", "onStartScript")
+	s.timedOut.Store(false)
+	if s.MaxRunTime > 0 {
+		timer := time.AfterFunc(time.Duration(s.MaxRunTime)*time.Second, func() {
+			s.runTimeout(stopped, stopOnce)
+		})
+		defer timer.Stop()
+	}
+	attemptStart, recorded, err := s.runAttempts(stopped, fields, s.runOnce, func(err error, start int64) {
+		s.DB.InsertAttempt(s.attemptRecord(err, s.snapshot(), start))
+	}, s.resetVM)
+	s.StopTime = time.Now().UnixMilli()
+	s.IsSuccess = true
+	record.Status = RunStatusSuccess
+	if err != nil && s.timedOut.Load() {
+		record.Status = RunStatusTimeout
+		s.ErrorMessage = fmt.Sprintf("run timeout after %ds: %s", s.MaxRunTime, err.Error())
+		common.SendMessage("error", s.Context, s.ErrorMessage)
+		common.PublishState(s.Context, common.StateTimeout, s.ErrorMessage)
+		s.Context.Error(s.ErrorMessage, fields...)
+		common.LoggerStd.Error(s.ErrorMessage, fields...)
+		s.IsSuccess = false
+	} else if err != nil {
+		record.Snapshot = s.snapshot()
+		record.Status = RunStatusError
+		s.ErrorMessage = err.Error()
+		common.SendMessage("error", s.Context, s.ErrorMessage)
+		common.PublishState(s.Context, common.StateError, s.ErrorMessage)
+		s.Context.Error(err.Error(), fields...)
+		common.LoggerStd.Error(err.Error(), fields...)
+		s.IsSuccess = false
+	}
+	/*有重试策略时记录每次尝试,前面失败的尝试已经在重试前记录*/
+	if s.Retry != nil && !recorded {
+		s.DB.InsertAttempt(s.attemptRecord(err, record.Snapshot, attemptStart))
+	}
+	record.ErrorMessage = s.ErrorMessage
+	record.StopTime = s.StopTime
+	s.DB.FinishRun(record)
+	metrics.ObserveRun(s.Context.ScriptUri, record.Status, time.Duration(s.StopTime-s.StartTime)*time.Millisecond)
+	common.SendMessage("stop", s.Context, "运行结束")
+	common.PublishState(s.Context, common.StateStopped, "运行结束")
+	/*数据发回监控中心，更新实例状态*/
+	s.remoteCall("Merkaba", "onStopScript")
+	if s.DB.Journal != nil {
+		s.DB.Journal.Finished(runId)
+	}
+	msg = "===>stop script"
+	s.Context.Info(msg, fields...)
+	/*最后才转为空闲,done关闭之前不能重新提交*/
+	s.DB.finishMemInstance(s)
+	close(done)
+}
+
+// runAttempts 按重试策略执行,failed在重试前记录失败的尝试,reset替换VM;
+// 返回最后一次尝试的开始时间,是否已经记录和错误
+func (s *ScriptInstance) runAttempts(stopped chan struct{}, fields []zap.Field, attempt func() error,
+	failed func(err error, start int64), reset func() error) (int64, bool, error) {
+	s.Attempt = 0
+	for {
+		s.Attempt += 1
+		start := time.Now().UnixMilli()
+		err := attempt()
+		if err == nil || !s.shouldRetry(err, stopped) {
+			return start, false, err
+		}
+		failed(err, start)
+		if !s.waitRetry(err, stopped, fields) {
+			return start, true, err
+		}
+		if resetErr := reset(); resetErr != nil {
+			return start, true, resetErr
+		}
+		/*替换VM期间收到的终止请求作用在上一个VM上,按上一次尝试的错误结束*/
+		if isClosed(stopped) {
+			return start, true, err
+		}
+	}
+}
+
+/*执行一次并清理VM*/
+func (s *ScriptInstance) runOnce() error {
+	err := s.runProgram()
+	s.RunVM.Clear()
+	return err
+}
+
+/*重试使用新的VM和浏览器,避免上一次的全局变量和页面状态*/
+func (s *ScriptInstance) resetVM() error {
+	previous := s.RunVM
+	err := s.InitVM()
+	previous.CloseWebClients()
+	return err
+}
+
+/*runId为空时分配新的runId*/
+func (s *ScriptInstance) newRun(runId string) {
+	if len(runId) == 0 {
+		runId = primitive.NewObjectID().Hex()
+	}
+	s.Context.RunId = runId
+	s.QueuedTime = time.Now().UnixMilli()
+	s.done = make(chan struct{})
+	s.stopped = make(chan struct{})
+	s.stopOnce = &sync.Once{}
+}
+
+/*编译并执行一次脚本*/
+func (s *ScriptInstance) runProgram() error {
+	vm := s.RunVM.Runtime
 	if s.Context.RunMode == common.RunModeBrowserRun {
 		if len(s.BreakPoints) > 0 {
-			debugger := s.RunVM.Runtime.AttachDebugger()
+			debugger := vm.AttachDebugger()
 			for _, breakPoint := range s.BreakPoints {
 				_scriptUri := breakPoint["scriptUri"].(string)
 				_lines := breakPoint["lines"].([]any)
@@ -75,35 +256,124 @@ func (s *ScriptInstance) Run() {
 			}
 		}
 	}
-	program, err := vm.Compile(s.Context.ScriptUri, s.ScriptContent, false, true, nil)
-	if err == nil {
-		if v, ok := s.Context.Parameters["enableVNC"]; s.Context.RunMode == common.RunModeBrowserRun && ok && v.(bool) {
-			common.StartVNC(common.VncWidth, common.VncHeight, s.Context.TaskName)
+	programs := Programs
+	if s.Context.ScriptVersion == InlineScriptVersion {
+		programs = InlinePrograms
+	}
+	s.RunVM.Registry.usePrograms(programs)
+	program, err := programs.Get(s.Context.ScriptUri, s.Context.ScriptVersion, s.ScriptContent, func() (*Program, error) {
+		return vm.Compile(s.Context.ScriptUri, s.ScriptContent, false, true, nil)
+	})
+	if err != nil {
+		return err
+	}
+	if v, ok := s.Context.Parameters["enableVNC"]; s.Attempt == 1 && s.Context.RunMode == common.RunModeBrowserRun && ok && v.(bool) {
+		if common.StartVNC(common.VncWidth, common.VncHeight, s.Context.TaskName) != nil {
 			common.LoggerStd.Info("启动VNC", zap.String("任务名称", s.Context.TaskName))
 		}
-		fnValue := vm.Get("handleTimeout")
-		if fnValue != nil {
-			vm.ExportTo(fnValue, &s.fnTimeout)
-		}
-		_, err = vm.RunProgram(program)
 	}
-	s.RunVM.Clear()
-	s.StopTime = time.Now().UnixMilli()
-	s.IsSuccess = true
+	fnValue := vm.Get("handleTimeout")
+	if fnValue != nil {
+		vm.ExportTo(fnValue, &s.fnTimeout)
+	}
+	_, err = vm.RunProgram(program)
+	return err
+}
+
+/*失败时的截图,多次尝试时按次数命名*/
+func (s *ScriptInstance) snapshot() string {
+	name := s.Context.TaskName
+	if s.Attempt > 1 {
+		name = fmt.Sprintf("%s_%d", name, s.Attempt)
+	}
+	fileName := s.RunVM.Snapshot(common.ArtifactDir(s.Context.RunId) + name)
+	if len(fileName) > 0 {
+		common.RecordArtifact(s.Context.RunId, common.ArtifactScreenshot, fileName)
+	}
+	return fileName
+}
+
+func (s *ScriptInstance) attemptRecord(err error, snapshot string, startTime int64) *AttemptRecord {
+	record := &AttemptRecord{
+		RunId:     s.Context.RunId,
+		Attempt:   s.Attempt,
+		Status:    RunStatusSuccess,
+		Snapshot:  snapshot,
+		StartTime: startTime,
+		StopTime:  time.Now().UnixMilli(),
+	}
 	if err != nil {
-		s.RunVM.Snapshot(common.Env.Path.Shot + "/" + s.Context.TaskName)
-		s.ErrorMessage = err.Error()
-		common.SendMessage("error", s.Context, s.ErrorMessage)
-		s.Context.Error(err.Error(), fields...)
-		common.LoggerStd.Error(err.Error(), fields...)
-		s.IsSuccess = false
+		record.Status = RunStatusError
+		record.ErrorClass = ErrorClass(err)
+		record.ErrorMessage = err.Error()
 	}
-	s.DB.FreeMemInstance(s)
-	common.SendMessage("stop", s.Context, "运行结束")
-	/*数据发回监控中心，更新实例状态*/
-	s.remoteCall("Merkaba", "onStopScript")
-	msg = "===>stop script"
-	s.Context.Info(msg, fields...)
+	return record
+}
+
+/*按重试策略判断是否重试*/
+func (s *ScriptInstance) shouldRetry(err error, stopped chan struct{}) bool {
+	return s.Retry != nil && s.Retry.ShouldRetry(s.Attempt, ErrorClass(err)) && !isClosed(stopped)
+}
+
+/*等待退避时间,等待期间被终止返回false*/
+func (s *ScriptInstance) waitRetry(err error, stopped chan struct{}, fields []zap.Field) bool {
+	delay := s.Retry.Delay(s.Attempt)
+	info := fmt.Sprintf("第%d次执行失败(%s),%v后重试: %s", s.Attempt, ErrorClass(err), delay, err.Error())
+	s.Context.Warn(info, fields...)
+	common.SendMessage("warn", s.Context, info)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stopped:
+		return false
+	}
+}
+
+// runTimeout 超过maxRunTime:终止VM,关闭浏览器(页面的context随之取消)
+func (s *ScriptInstance) runTimeout(stopped chan struct{}, stopOnce *sync.Once) {
+	s.timedOut.Store(true)
+	info := fmt.Sprintf("运行超过%d秒,终止执行", s.MaxRunTime)
+	s.Context.Warn(info, zap.String("runId", s.Context.RunId))
+	s.RunVM.Runtime.Interrupt(info)
+	stopOnce.Do(func() { close(stopped) })
+	s.Context.Pause.Resume()
+	s.RunVM.CloseWebClients()
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+/*上一次执行已经结束,还没有执行过时也返回true*/
+func (s *ScriptInstance) runDone() bool {
+	return s.done == nil || isClosed(s.done)
+}
+
+// Done 返回当前这次执行结束时关闭的通道,需要在Queued之后调用
+func (s *ScriptInstance) Done() <-chan struct{} {
+	return s.done
+}
+
+// QueueInfo 执行队列按优先级,站点和账号调度
+func (s *ScriptInstance) QueueInfo() queue.TaskInfo {
+	return queue.TaskInfo{
+		Priority: s.Priority,
+		Site:     s.Context.SiteName,
+		Account:  s.Context.Account(),
+	}
+}
+
+// Queued 实例已经提交到执行队列,runId为空时分配新的runId
+func (s *ScriptInstance) Queued(runId string) {
+	s.newRun(runId)
+	common.PublishState(s.Context, common.StateQueued, "等待执行")
 }
 
 func (s *ScriptInstance) remoteCall(service string, funcName string) {
@@ -122,22 +392,106 @@ func (s *ScriptInstance) remoteCall(service string, funcName string) {
 	common.LoggerStd.Info(service+"."+funcName, zap.String("result", result.(string)), zap.String("scriptUri", s.Context.ScriptUri))
 }
 
+// Interrupt 终止运行中的脚本,只发出信号,Run退出时实例才转为空闲
 func (s *ScriptInstance) Interrupt() {
+	if !s.Busy() {
+		return
+	}
 	info := "用户终止"
 	common.SendMessage("stop", s.Context, info)
 	s.RunVM.Runtime.Interrupt(info)
-	s.DB.FreeMemInstance(s)
+	if s.stopOnce != nil {
+		s.stopOnce.Do(func() { close(s.stopped) })
+	}
+	/*暂停中的VM需要唤醒才能响应终止*/
+	s.Context.Pause.Resume()
 	s.RunVM.CloseWebClients()
 }
 
+// Pause 暂停运行中的脚本,VM在下一条指令或者WebPage操作之前阻塞
+func (s *ScriptInstance) Pause() bool {
+	if s.Status() != InstanceRunning || !s.Context.Pause.Pause() {
+		return false
+	}
+	info := "用户暂停"
+	s.Context.Info(info, zap.String("runId", s.Context.RunId))
+	common.SendMessage("pause", s.Context, info)
+	common.PublishState(s.Context, common.StatePaused, info)
+	return true
+}
+
+// Resume 恢复暂停的脚本
+func (s *ScriptInstance) Resume() bool {
+	if !s.Context.Pause.Resume() {
+		return false
+	}
+	info := "用户恢复"
+	s.Context.Info(info, zap.String("runId", s.Context.RunId))
+	common.SendMessage("resume", s.Context, info)
+	common.PublishState(s.Context, common.StateResumed, info)
+	return true
+}
+
+// Cancel 取消在执行队列中等待的实例,调用方需要先把实例从队列中移除
+func (s *ScriptInstance) Cancel(info string) {
+	runId := s.Context.RunId
+	done := s.done
+	s.StartTime = time.Now().UnixMilli()
+	s.StopTime = s.StartTime
+	s.IsSuccess = false
+	s.ErrorMessage = info
+	record := newRunRecord(s)
+	record.Status = RunStatusCancelled
+	record.ErrorMessage = info
+	record.StopTime = s.StopTime
+	s.DB.InsertRun(record)
+	s.Context.Info("cancel queued script", zap.String("runId", runId))
+	common.SendMessage("stop", s.Context, info)
+	common.PublishState(s.Context, common.StateStopped, info)
+	s.remoteCall("Merkaba", "onStopScript")
+	if s.DB.Journal != nil {
+		s.DB.Journal.Finished(runId)
+	}
+	s.DB.FreeMemInstance(s)
+	close(done)
+}
+
+// Discard 提交到执行队列失败,实例转为空闲,结束这次执行
+func (s *ScriptInstance) Discard() {
+	done := s.done
+	s.DB.FreeMemInstance(s)
+	close(done)
+}
+
+// ReportInterrupted 节点重启前没有结束的执行,或者重放时不能再提交的任务,记录为失败并通知监控中心
+func (s *ScriptInstance) ReportInterrupted(info string) {
+	s.StopTime = time.Now().UnixMilli()
+	s.IsSuccess = false
+	s.ErrorMessage = info
+	record := newRunRecord(s)
+	record.Status = RunStatusError
+	record.ErrorMessage = info
+	record.StopTime = s.StopTime
+	/*没有开始执行的任务还没有执行记录*/
+	if s.StartTime == 0 {
+		s.DB.InsertRun(record)
+	} else {
+		s.DB.FinishRun(record)
+	}
+	common.LoggerStd.Warn("run interrupted", zap.String("taskName", s.Context.TaskName), zap.String("runId", s.Context.RunId))
+	s.remoteCall("Merkaba", "onStopScript")
+}
+
 func (s *ScriptInstance) AsMap() map[string]any {
 	data := make(map[string]any)
 	data["siteName"] = s.Context.SiteName
 	data["scriptId"] = s.Context.ScriptId
 	data["taskName"] = s.Context.TaskName
+	data["runId"] = s.Context.RunId
 	data["scriptUri"] = s.Context.ScriptUri
 	data["cookieId"] = s.Context.CookieId
 	data["scriptVersion"] = s.Context.ScriptVersion
+	data["channel"] = s.Context.ScriptChannel
 	data["parameters"] = s.Context.Parameters
 	data["isSuccess"] = s.IsSuccess
 	data["ip"] = common.LocalIP
@@ -146,7 +500,19 @@ func (s *ScriptInstance) AsMap() map[string]any {
 	data["hasVNC"] = common.HasVNC(s.Context.TaskName)
 	data["stopTime"] = s.StopTime
 	data["error"] = s.ErrorMessage
-	if s.Status == "Running" {
+	data["attempt"] = s.Attempt
+	data["maxRunTime"] = s.MaxRunTime
+	data["timedOut"] = s.timedOut.Load()
+	if s.Retry != nil {
+		data["maxAttempts"] = s.Retry.MaxAttempts
+	}
+	/*0:空闲 1:运行 2:暂停 3:等待执行*/
+	status := s.Status()
+	if status == InstanceQueued {
+		data["status"] = 3
+	} else if status == InstanceRunning && s.Context.Pause.Paused() {
+		data["status"] = 2
+	} else if status == InstanceRunning {
 		data["status"] = 1
 	} else {
 		data["status"] = 0
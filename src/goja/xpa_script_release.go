@@ -0,0 +1,297 @@
+package goja
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"math/rand"
+	"merkaba/common"
+	"sync"
+	"time"
+)
+
+var (
+	ErrScriptNotFound      = errors.New("script not found")
+	ErrVersionNotFound     = errors.New("script version not found")
+	ErrVersionNotSupported = errors.New("script store does not support versions")
+	ErrChannelNotSupported = errors.New("script store does not support channels")
+)
+
+// CanaryRule 灰度规则:没有指定版本和渠道的执行,按Percent的比例使用候选版本,保存在merkaba_script_canary表
+type CanaryRule struct {
+	ScriptId    string `db:"scriptId"`
+	Version     string `db:"version"`
+	Percent     int    `db:"percent"`
+	CreatedTime int64  `db:"createdTime"`
+}
+
+func (r *CanaryRule) AsMap() map[string]any {
+	data := make(map[string]any)
+	data["scriptId"] = r.ScriptId
+	data["version"] = r.Version
+	data["percent"] = r.Percent
+	data["createdTime"] = r.CreatedTime
+	return data
+}
+
+// ScriptVersion 发布过的版本,保存在merkaba_script_version表
+type ScriptVersion struct {
+	ScriptId    string `db:"scriptId"`
+	Version     string `db:"version"`
+	CreatedTime int64  `db:"createdTime"`
+}
+
+func (v *ScriptVersion) AsMap() map[string]any {
+	data := make(map[string]any)
+	data["scriptId"] = v.ScriptId
+	data["version"] = v.Version
+	data["createdTime"] = v.CreatedTime
+	return data
+}
+
+// ScriptRelease 正式版本的一次启用,保存在merkaba_script_release表;回滚时按启用的顺序回退
+type ScriptRelease struct {
+	Id          int64  `db:"id"`
+	ScriptId    string `db:"scriptId"`
+	Version     string `db:"version"`
+	RolledBack  bool   `db:"rolledBack"`
+	CreatedTime int64  `db:"createdTime"`
+}
+
+func (r *ScriptRelease) AsMap() map[string]any {
+	data := make(map[string]any)
+	data["scriptId"] = r.ScriptId
+	data["version"] = r.Version
+	data["rolledBack"] = r.RolledBack
+	data["createdTime"] = r.CreatedTime
+	return data
+}
+
+// ResolveScript 按版本或者渠道选择脚本,返回实际使用的版本和渠道;指定版本时渠道为空
+func (db *ScriptDb) ResolveScript(scriptId string, version string, channel string) (content string, resolved string, resolvedChannel string, err error) {
+	var rule *CanaryRule
+	if _, ok := db.Store.(common.ChannelScriptStore); ok && len(version) == 0 &&
+		(len(channel) == 0 || channel == common.ScriptChannelCanary) {
+		rule = db.ReadCanary(scriptId)
+	}
+	content, resolved, resolvedChannel, err = resolveScript(db.Store, scriptId, version, channel, rule, rand.Intn(100))
+	if err == nil && resolvedChannel == common.ScriptChannelPrd {
+		db.recordVersion(scriptId, content, resolved)
+	}
+	return content, resolved, resolvedChannel, err
+}
+
+/*roll是0~99的随机数,小于灰度比例时使用候选版本*/
+func resolveScript(store common.ScriptStore, scriptId string, version string, channel string,
+	rule *CanaryRule, roll int) (content string, resolved string, resolvedChannel string, err error) {
+	if len(version) > 0 {
+		versioned, ok := store.(common.VersionedScriptStore)
+		if !ok {
+			return "", "", "", ErrVersionNotSupported
+		}
+		content, resolved = versioned.ReadScriptVersion(scriptId, version)
+		if len(content) == 0 {
+			return "", "", "", ErrVersionNotFound
+		}
+		return content, resolved, "", nil
+	}
+	channels, ok := store.(common.ChannelScriptStore)
+	if !ok {
+		if len(channel) > 0 && channel != common.DefaultScriptChannel() {
+			return "", "", "", ErrChannelNotSupported
+		}
+		content, resolved = store.ReadScript(scriptId)
+		if len(content) == 0 {
+			return "", "", "", ErrScriptNotFound
+		}
+		return content, resolved, common.DefaultScriptChannel(), nil
+	}
+	if len(channel) == 0 {
+		channel = common.DefaultScriptChannel()
+		if rule != nil && roll < rule.Percent {
+			channel = common.ScriptChannelCanary
+		}
+	}
+	if channel == common.ScriptChannelCanary {
+		if rule != nil {
+			if versioned, ok := store.(common.VersionedScriptStore); ok {
+				content, resolved = versioned.ReadScriptVersion(scriptId, rule.Version)
+				if len(content) > 0 {
+					return content, resolved, channel, nil
+				}
+			}
+			common.LoggerStd.Warn("canary version not found", zap.String("scriptId", scriptId), zap.String("version", rule.Version))
+		}
+		/*没有灰度规则时使用正式版本*/
+		channel = common.ScriptChannelPrd
+	}
+	content, resolved = channels.ReadScriptChannel(scriptId, channel)
+	if len(content) == 0 {
+		return "", "", "", ErrScriptNotFound
+	}
+	return content, resolved, channel, nil
+}
+
+/*每个脚本最近一次记录的正式版本,版本变化时才写数据库*/
+var recordedVersions sync.Map
+
+// recordVersion 正式版本保存到历史,按版本执行、灰度和回滚时使用;版本变化时记录一次启用
+func (db *ScriptDb) recordVersion(scriptId string, content string, version string) {
+	if _, ok := db.Store.(*common.MysqlScriptStore); !ok || len(version) == 0 || len(content) == 0 {
+		return
+	}
+	if last, ok := recordedVersions.Load(scriptId); ok && last == version {
+		return
+	}
+	recordedVersions.Store(scriptId, version)
+	sql := `insert ignore into merkaba_script_version(scriptId,version,content,createdTime) values(?,?,?,?)`
+	if _, err := db.Client.Update(sql, scriptId, version, content, time.Now().UnixMilli()); err != nil {
+		recordedVersions.Delete(scriptId)
+		common.LoggerStd.Error(sql, zap.Error(err))
+		return
+	}
+	if err := db.recordRelease(scriptId, version); err != nil {
+		recordedVersions.Delete(scriptId)
+	}
+}
+
+// recordRelease 最近一次启用的不是version时记录一次启用
+func (db *ScriptDb) recordRelease(scriptId string, version string) error {
+	var latest []string
+	sql := `select version from merkaba_script_release where scriptId=? and rolledBack=0 order by id desc limit 1`
+	if err := db.Client.Select(&latest, sql, scriptId); err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+		return err
+	}
+	if len(latest) > 0 && latest[0] == version {
+		return nil
+	}
+	sql = `insert into merkaba_script_release(scriptId,version,rolledBack,createdTime) values(?,?,0,?)`
+	if _, err := db.Client.Update(sql, scriptId, version, time.Now().UnixMilli()); err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *ScriptDb) ReadCanary(scriptId string) *CanaryRule {
+	var result []CanaryRule
+	sql := `select scriptId,version,percent,createdTime from merkaba_script_canary where scriptId=?`
+	if err := db.Client.Select(&result, sql, scriptId); err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+		return nil
+	}
+	if len(result) == 0 || result[0].Percent <= 0 {
+		return nil
+	}
+	return &result[0]
+}
+
+func (db *ScriptDb) SaveCanary(rule *CanaryRule) error {
+	sql := `insert into merkaba_script_canary(scriptId,version,percent,createdTime) values(?,?,?,?)
+            on duplicate key update version=values(version),percent=values(percent),createdTime=values(createdTime)`
+	_, err := db.Client.Update(sql, rule.ScriptId, rule.Version, rule.Percent, rule.CreatedTime)
+	if err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+	}
+	return err
+}
+
+func (db *ScriptDb) DeleteCanary(scriptId string) error {
+	sql := `delete from merkaba_script_canary where scriptId=?`
+	_, err := db.Client.Update(sql, scriptId)
+	if err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+	}
+	return err
+}
+
+// ReadScriptVersions 发布过的版本,最新的在前面
+func (db *ScriptDb) ReadScriptVersions(scriptId string) ([]ScriptVersion, error) {
+	sql := `select scriptId,version,createdTime from merkaba_script_version where scriptId=? order by createdTime desc`
+	result := make([]ScriptVersion, 0)
+	err := db.Client.Select(&result, sql, scriptId)
+	return result, err
+}
+
+// ReadScriptReleases 正式版本的启用记录,最新的在前面
+func (db *ScriptDb) ReadScriptReleases(scriptId string) ([]ScriptRelease, error) {
+	sql := `select id,scriptId,version,rolledBack,createdTime from merkaba_script_release where scriptId=? order by id desc limit 100`
+	result := make([]ScriptRelease, 0)
+	err := db.Client.Select(&result, sql, scriptId)
+	return result, err
+}
+
+// Rollback 正式版本回滚到version,version为空时回滚到当前版本启用之前的版本;
+// 当前版本的启用记录标记为已回滚,当前版本先保存到历史,同时取消灰度
+func (db *ScriptDb) Rollback(scriptId string, version string) (from string, to string, err error) {
+	store, ok := db.Store.(*common.MysqlScriptStore)
+	if !ok {
+		return "", "", ErrVersionNotSupported
+	}
+	_, from = store.ReadScriptChannel(scriptId, common.ScriptChannelPrd)
+	releases, err := db.ReadScriptReleases(scriptId)
+	if err != nil {
+		return "", "", err
+	}
+	target, rolledBack, activate := rollbackTarget(releases, from, version)
+	if len(target) == 0 {
+		return from, "", ErrVersionNotFound
+	}
+	content, to := store.ReadScriptVersion(scriptId, target)
+	if len(content) == 0 {
+		return from, "", ErrVersionNotFound
+	}
+	sql := `insert ignore into merkaba_script_version(scriptId,version,content,createdTime)
+            select id,version,content,? from merkaba_script_prd where id=?`
+	if _, err = db.Client.Update(sql, time.Now().UnixMilli(), scriptId); err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+		return from, "", err
+	}
+	sql = `update merkaba_script_prd set content=?,version=? where id=?`
+	if _, err = db.Client.Update(sql, content, to, scriptId); err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+		return from, "", err
+	}
+	if rolledBack > 0 {
+		sql = `update merkaba_script_release set rolledBack=1 where scriptId=? and rolledBack=0 and id>=?`
+		if _, err = db.Client.Update(sql, scriptId, rolledBack); err != nil {
+			common.LoggerStd.Error(sql, zap.Error(err))
+		}
+	}
+	if activate {
+		sql = `insert into merkaba_script_release(scriptId,version,rolledBack,createdTime) values(?,?,0,?)`
+		if _, err = db.Client.Update(sql, scriptId, to, time.Now().UnixMilli()); err != nil {
+			common.LoggerStd.Error(sql, zap.Error(err))
+		}
+	}
+	recordedVersions.Store(scriptId, to)
+	db.DeleteCanary(scriptId)
+	common.LoggerStd.Info("script rollback", zap.String("scriptId", scriptId), zap.String("from", from), zap.String("to", to))
+	return from, to, nil
+}
+
+// rollbackTarget 按启用记录(最新的在前面)计算回滚的目标版本:
+// rolledBack是当前版本最早的一条连续启用记录的id,从它开始标记为已回滚,0表示没有;
+// activate为true时目标版本需要记录为新的启用(指定了版本,或者目标不是上一次启用的版本)
+func rollbackTarget(releases []ScriptRelease, current string, version string) (target string, rolledBack int64, activate bool) {
+	active := make([]ScriptRelease, 0, len(releases))
+	for _, r := range releases {
+		if !r.RolledBack {
+			active = append(active, r)
+		}
+	}
+	i := 0
+	for i < len(active) && active[i].Version == current {
+		rolledBack = active[i].Id
+		i++
+	}
+	target = version
+	if len(target) == 0 && i < len(active) {
+		target = active[i].Version
+	}
+	if len(target) == 0 {
+		return "", 0, false
+	}
+	activate = i >= len(active) || active[i].Version != target
+	return target, rolledBack, activate
+}
@@ -0,0 +1,129 @@
+package goja
+
+import (
+	"merkaba/common"
+	"testing"
+)
+
+/*按渠道和版本保存脚本,内容为 渠道或者版本:id*/
+type releaseStore struct {
+	channels map[string]string
+	versions map[string]bool
+}
+
+func (s *releaseStore) ReadScript(id string) (string, string) {
+	return s.ReadScriptChannel(id, common.DefaultScriptChannel())
+}
+
+func (s *releaseStore) ReadScriptId(uri string) string {
+	return uri
+}
+
+func (s *releaseStore) ReadScriptChannel(id string, channel string) (string, string) {
+	version, ok := s.channels[channel]
+	if !ok {
+		return "", ""
+	}
+	return channel + ":" + id, version
+}
+
+func (s *releaseStore) ReadScriptVersion(id string, version string) (string, string) {
+	if !s.versions[version] {
+		return "", ""
+	}
+	return version + ":" + id, version
+}
+
+/*只能读取当前版本*/
+type plainStore struct{}
+
+func (plainStore) ReadScript(id string) (string, string) { return "plain:" + id, "v0" }
+func (plainStore) ReadScriptId(uri string) string        { return uri }
+
+func TestResolveScript(t *testing.T) {
+	store := &releaseStore{
+		channels: map[string]string{common.ScriptChannelDev: "v3", common.ScriptChannelPrd: "v2"},
+		versions: map[string]bool{"v1": true, "v2": true, "v3": true},
+	}
+	rule := &CanaryRule{ScriptId: "s", Version: "v3", Percent: 20}
+	cases := []struct {
+		version, channel     string
+		rule                 *CanaryRule
+		roll                 int
+		content, channelWant string
+	}{
+		{"v1", "", nil, 0, "v1:s", ""},
+		{"", common.ScriptChannelPrd, nil, 0, "prd:s", common.ScriptChannelPrd},
+		{"", "", nil, 0, "dev:s", common.ScriptChannelDev},
+		/*按比例进入灰度*/
+		{"", "", rule, 19, "v3:s", common.ScriptChannelCanary},
+		{"", "", rule, 20, "dev:s", common.ScriptChannelDev},
+		{"", common.ScriptChannelCanary, rule, 99, "v3:s", common.ScriptChannelCanary},
+		/*没有灰度规则时使用正式版本*/
+		{"", common.ScriptChannelCanary, nil, 0, "prd:s", common.ScriptChannelPrd},
+	}
+	for i, c := range cases {
+		content, _, channel, err := resolveScript(store, "s", c.version, c.channel, c.rule, c.roll)
+		if err != nil || content != c.content || channel != c.channelWant {
+			t.Fatalf("case %d: content=%s channel=%s err=%v", i, content, channel, err)
+		}
+	}
+	if _, _, _, err := resolveScript(store, "s", "v9", "", nil, 0); err != ErrVersionNotFound {
+		t.Fatalf("missing version: %v", err)
+	}
+	/*没有发布到渠道的脚本*/
+	if _, _, _, err := resolveScript(&releaseStore{}, "s", "", common.ScriptChannelPrd, nil, 0); err != ErrScriptNotFound {
+		t.Fatalf("missing script: %v", err)
+	}
+	if _, _, _, err := resolveScript(plainStore{}, "s", "v1", "", nil, 0); err != ErrVersionNotSupported {
+		t.Fatalf("plain version: %v", err)
+	}
+	if _, _, _, err := resolveScript(plainStore{}, "s", "", common.ScriptChannelPrd, nil, 0); err != ErrChannelNotSupported {
+		t.Fatalf("plain channel: %v", err)
+	}
+	content, version, channel, err := resolveScript(plainStore{}, "s", "", "", rule, 0)
+	if err != nil || content != "plain:s" || version != "v0" || channel != common.ScriptChannelDev {
+		t.Fatalf("plain: %s %s %s %v", content, version, channel, err)
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	/*最新的在前面:v1,v2,v3依次启用,v4启用后已经回滚*/
+	releases := []ScriptRelease{
+		{Id: 5, Version: "v4", RolledBack: true},
+		{Id: 4, Version: "v3"},
+		{Id: 3, Version: "v3"},
+		{Id: 2, Version: "v2"},
+		{Id: 1, Version: "v1"},
+	}
+	cases := []struct {
+		current, version string
+		target           string
+		rolledBack       int64
+		activate         bool
+	}{
+		{"v3", "", "v2", 3, false},
+		/*指定版本时记录为新的启用*/
+		{"v3", "v1", "v1", 3, true},
+		/*当前版本不在记录中,或者重新发布后还没有记录,回到最近一次启用的版本*/
+		{"v9", "", "v3", 0, false},
+		{"v1", "", "v3", 0, false},
+	}
+	for _, c := range cases {
+		target, rolledBack, activate := rollbackTarget(releases, c.current, c.version)
+		if target != c.target || rolledBack != c.rolledBack || activate != c.activate {
+			t.Fatalf("%s->%s: target=%s rolledBack=%d activate=%v", c.current, c.version, target, rolledBack, activate)
+		}
+	}
+	/*回滚两次:v3回滚后再从v2回滚到v1*/
+	releases[1].RolledBack, releases[2].RolledBack = true, true
+	if target, rolledBack, _ := rollbackTarget(releases, "v2", ""); target != "v1" || rolledBack != 2 {
+		t.Fatalf("second rollback: %s %d", target, rolledBack)
+	}
+	if target, _, _ := rollbackTarget(nil, "v1", ""); target != "" {
+		t.Fatalf("empty history: %s", target)
+	}
+	if target, _, _ := rollbackTarget(releases[4:], "v1", ""); target != "" {
+		t.Fatalf("first release: %s", target)
+	}
+}
@@ -0,0 +1,156 @@
+package goja
+
+import (
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"strings"
+	"time"
+)
+
+/*失败原因的分类,重试策略按分类判断是否重试*/
+const (
+	ErrorClassTimeout     = "timeout"
+	ErrorClassBrowser     = "browser"
+	ErrorClassRpc         = "rpc"
+	ErrorClassScript      = "script"
+	ErrorClassInterrupted = "interrupted"
+	ErrorClassAny         = "any"
+)
+
+const (
+	defaultRetryBackoff    = 1000
+	defaultRetryMaxBackoff = 60000
+	defaultRetryMultiplier = 2
+)
+
+/*没有指定retryOn时只重试偶发的错误*/
+var defaultRetryOn = []string{ErrorClassTimeout, ErrorClassBrowser, ErrorClassRpc}
+
+// RetryPolicy 执行失败后的重试策略,MaxAttempts包含第一次执行,时间单位是毫秒
+type RetryPolicy struct {
+	MaxAttempts int      `json:"maxAttempts"`
+	Backoff     int64    `json:"backoff"`
+	MaxBackoff  int64    `json:"maxBackoff"`
+	Multiplier  float64  `json:"multiplier"`
+	RetryOn     []string `json:"retryOn"`
+}
+
+// ShouldRetry 第attempt次执行失败后是否需要重试,用户终止的不重试
+func (p *RetryPolicy) ShouldRetry(attempt int, class string) bool {
+	if attempt >= p.MaxAttempts || class == ErrorClassInterrupted {
+		return false
+	}
+	retryOn := p.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = defaultRetryOn
+	}
+	for _, c := range retryOn {
+		if c == class || c == ErrorClassAny {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay 第attempt次执行失败后等待的时间,按倍数递增,不超过MaxBackoff
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	backoff := float64(p.Backoff)
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultRetryMultiplier
+	}
+	maxBackoff := float64(p.MaxBackoff)
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= multiplier
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return time.Duration(backoff) * time.Millisecond
+}
+
+// ErrorClass 按错误信息判断失败的分类
+func ErrorClass(err error) string {
+	var interrupted *InterruptedError
+	if errors.As(err, &interrupted) {
+		return ErrorClassInterrupted
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "rpc error"):
+		return ErrorClassRpc
+	case strings.Contains(msg, "time out") || strings.Contains(msg, "timeout") ||
+		strings.Contains(msg, "deadline exceeded"):
+		return ErrorClassTimeout
+	case strings.Contains(msg, "websocket") || strings.Contains(msg, "target closed") ||
+		strings.Contains(msg, "context canceled") || strings.Contains(msg, "chrome") ||
+		strings.Contains(msg, "invalid context"):
+		return ErrorClassBrowser
+	default:
+		return ErrorClassScript
+	}
+}
+
+// AttemptRecord 一次执行中的每次尝试,保存在merkaba_run_attempt表
+type AttemptRecord struct {
+	RunId        string `db:"runId"`
+	Attempt      int    `db:"attempt"`
+	Status       string `db:"status"`
+	ErrorClass   string `db:"errorClass"`
+	ErrorMessage string `db:"error"`
+	Snapshot     string `db:"snapshot"`
+	StartTime    int64  `db:"startTime"`
+	StopTime     int64  `db:"stopTime"`
+}
+
+func (r *AttemptRecord) AsMap() map[string]any {
+	data := make(map[string]any)
+	data["attempt"] = r.Attempt
+	data["status"] = r.Status
+	data["errorClass"] = r.ErrorClass
+	data["error"] = r.ErrorMessage
+	data["snapshot"] = r.Snapshot
+	data["startTime"] = r.StartTime
+	data["stopTime"] = r.StopTime
+	return data
+}
+
+const attemptColumns = `runId,attempt,status,errorClass,error,snapshot,startTime,stopTime`
+
+func (db *ScriptDb) InsertAttempt(r *AttemptRecord) {
+	sql := `insert into merkaba_run_attempt(` + attemptColumns + `) values(?,?,?,?,?,?,?,?)`
+	_, err := db.Client.Update(sql, r.RunId, r.Attempt, r.Status, r.ErrorClass, r.ErrorMessage, r.Snapshot, r.StartTime, r.StopTime)
+	if err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+	}
+}
+
+func (db *ScriptDb) ReadAttempts(runId string) ([]AttemptRecord, error) {
+	sql := `select ` + attemptColumns + ` from merkaba_run_attempt where runId=? order by attempt`
+	result := make([]AttemptRecord, 0)
+	err := db.Client.Select(&result, sql, runId)
+	return result, err
+}
+
+// ReadRetryPolicy 脚本配置的重试策略,保存在merkaba表的retryPolicy(json),没有配置时返回nil
+func (db *ScriptDb) ReadRetryPolicy(scriptId string) *RetryPolicy {
+	var values []string
+	err := db.Client.Select(&values, "select ifnull(retryPolicy,'') from merkaba where id=?", scriptId)
+	if err != nil || len(values) == 0 || len(values[0]) == 0 {
+		return nil
+	}
+	var policy RetryPolicy
+	if err = json.Unmarshal([]byte(values[0]), &policy); err != nil {
+		common.LoggerStd.Error("retryPolicy of "+scriptId, zap.Error(err))
+		return nil
+	}
+	return &policy
+}
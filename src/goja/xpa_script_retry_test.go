@@ -0,0 +1,109 @@
+package goja
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"testing"
+	"time"
+)
+
+func TestErrorClass(t *testing.T) {
+	cases := map[string]error{
+		ErrorClassTimeout:     errors.New("wait #login time out"),
+		ErrorClassBrowser:     errors.New("websocket: close 1006"),
+		ErrorClassRpc:         errors.New("rpc error: code = Unavailable"),
+		ErrorClassScript:      errors.New("ReferenceError: a is not defined"),
+		ErrorClassInterrupted: fmt.Errorf("run: %w", &InterruptedError{iface: "用户终止"}),
+	}
+	for class, err := range cases {
+		if c := ErrorClass(err); c != class {
+			t.Fatalf("%s: class %s, want %s", err.Error(), c, class)
+		}
+	}
+	/*超时同时包含context canceled时按超时处理*/
+	if c := ErrorClass(errors.New("context canceled: deadline exceeded")); c != ErrorClassTimeout {
+		t.Fatalf("class %s", c)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3}
+	if !policy.ShouldRetry(1, ErrorClassTimeout) || !policy.ShouldRetry(2, ErrorClassBrowser) {
+		t.Fatal("default retryOn")
+	}
+	if policy.ShouldRetry(1, ErrorClassScript) {
+		t.Fatal("script errors are not retried by default")
+	}
+	if policy.ShouldRetry(3, ErrorClassTimeout) {
+		t.Fatal("maxAttempts includes the first run")
+	}
+	policy.RetryOn = []string{ErrorClassAny}
+	if !policy.ShouldRetry(1, ErrorClassScript) {
+		t.Fatal("any")
+	}
+	if policy.ShouldRetry(1, ErrorClassInterrupted) {
+		t.Fatal("interrupted runs are never retried")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := &RetryPolicy{Backoff: 100, MaxBackoff: 500, Multiplier: 3}
+	for attempt, want := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 300 * time.Millisecond,
+		3: 500 * time.Millisecond,
+		9: 500 * time.Millisecond,
+	} {
+		if d := policy.Delay(attempt); d != want {
+			t.Fatalf("attempt %d: delay %v, want %v", attempt, d, want)
+		}
+	}
+	/*没有配置时使用默认值*/
+	empty := &RetryPolicy{}
+	if d := empty.Delay(1); d != defaultRetryBackoff*time.Millisecond {
+		t.Fatalf("default delay %v", d)
+	}
+	if d := empty.Delay(2); d != defaultRetryBackoff*defaultRetryMultiplier*time.Millisecond {
+		t.Fatalf("default multiplier %v", d)
+	}
+}
+
+func retryInstance() *ScriptInstance {
+	common.LoggerStd = zap.NewNop()
+	return &ScriptInstance{
+		Context: &common.RunContext{TaskName: "retry"},
+		Retry:   &RetryPolicy{MaxAttempts: 3, Backoff: 1},
+	}
+}
+
+func TestRunAttempts(t *testing.T) {
+	s := retryInstance()
+	failures := 0
+	_, recorded, err := s.runAttempts(make(chan struct{}), nil, func() error {
+		if s.Attempt < 3 {
+			return errors.New("wait #login time out")
+		}
+		return nil
+	}, func(err error, start int64) { failures++ }, func() error { return nil })
+	if err != nil || recorded || s.Attempt != 3 || failures != 2 {
+		t.Fatalf("err %v, recorded %v, attempt %d, failures %d", err, recorded, s.Attempt, failures)
+	}
+}
+
+/*替换VM期间被终止,按上一次尝试的错误结束,不能记为成功*/
+func TestRunAttemptsStoppedDuringReset(t *testing.T) {
+	s := retryInstance()
+	stopped := make(chan struct{})
+	failed := errors.New("wait #login time out")
+	_, recorded, err := s.runAttempts(stopped, nil, func() error {
+		return failed
+	}, func(err error, start int64) {}, func() error {
+		close(stopped)
+		return nil
+	})
+	if err != failed || !recorded || s.Attempt != 1 {
+		t.Fatalf("err %v, recorded %v, attempt %d", err, recorded, s.Attempt)
+	}
+}
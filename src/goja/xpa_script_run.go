@@ -0,0 +1,181 @@
+package goja
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"strings"
+)
+
+const (
+	RunStatusRunning   = "Running"
+	RunStatusSuccess   = "Success"
+	RunStatusError     = "Error"
+	RunStatusCancelled = "Cancelled" /*在执行队列中等待时被取消*/
+	RunStatusTimeout   = "Timeout"   /*超过maxRunTime被终止*/
+)
+
+// RunRecord 一次脚本执行的记录,保存在merkaba_run表
+type RunRecord struct {
+	Id            string `db:"id"`
+	TaskName      string `db:"taskName"`
+	SiteName      string `db:"siteName"`
+	ScriptId      string `db:"scriptId"`
+	ScriptUri     string `db:"scriptUri"`
+	ScriptVersion string `db:"scriptVersion"`
+	Channel       string `db:"channel"`
+	RunMode       int    `db:"runMode"`
+	Parameters    string `db:"parameters"`
+	Status        string `db:"status"`
+	ErrorMessage  string `db:"error"`
+	Snapshot      string `db:"snapshot"`
+	IP            string `db:"ip"`
+	Server        string `db:"server"`
+	QueuedTime    int64  `db:"queuedTime"`
+	StartTime     int64  `db:"startTime"`
+	StopTime      int64  `db:"stopTime"`
+}
+
+// RunFilter 查询执行记录的条件,空值表示不过滤
+type RunFilter struct {
+	ScriptUri string
+	TaskName  string
+	Status    string
+	From      int64
+	To        int64
+	Limit     int
+	Offset    int
+}
+
+const runColumns = `id,taskName,siteName,scriptId,scriptUri,scriptVersion,channel,runMode,parameters,status,error,snapshot,ip,server,queuedTime,startTime,stopTime`
+
+func newRunRecord(s *ScriptInstance) *RunRecord {
+	ctx := s.Context
+	parameters, _ := json.Marshal(ctx.Parameters)
+	return &RunRecord{
+		Id:            ctx.RunId,
+		TaskName:      ctx.TaskName,
+		SiteName:      ctx.SiteName,
+		ScriptId:      ctx.ScriptId,
+		ScriptUri:     ctx.ScriptUri,
+		ScriptVersion: ctx.ScriptVersion,
+		Channel:       ctx.ScriptChannel,
+		RunMode:       int(ctx.RunMode),
+		Parameters:    string(parameters),
+		Status:        RunStatusRunning,
+		IP:            common.LocalIP,
+		Server:        common.LocalName,
+		QueuedTime:    s.QueuedTime,
+		StartTime:     s.StartTime,
+	}
+}
+
+func (r *RunRecord) AsMap() map[string]any {
+	data := make(map[string]any)
+	data["id"] = r.Id
+	data["taskName"] = r.TaskName
+	data["siteName"] = r.SiteName
+	data["scriptId"] = r.ScriptId
+	data["scriptUri"] = r.ScriptUri
+	data["scriptVersion"] = r.ScriptVersion
+	data["channel"] = r.Channel
+	data["runMode"] = r.RunMode
+	var parameters map[string]any
+	json.Unmarshal([]byte(r.Parameters), &parameters)
+	data["parameters"] = parameters
+	data["status"] = r.Status
+	data["error"] = r.ErrorMessage
+	data["snapshot"] = r.Snapshot
+	data["ip"] = r.IP
+	data["server"] = r.Server
+	data["queuedTime"] = r.QueuedTime
+	data["startTime"] = r.StartTime
+	data["stopTime"] = r.StopTime
+	return data
+}
+
+func (db *ScriptDb) InsertRun(r *RunRecord) {
+	sql := `insert into merkaba_run(` + runColumns + `) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+	_, err := db.Client.Update(sql, r.Id, r.TaskName, r.SiteName, r.ScriptId, r.ScriptUri, r.ScriptVersion, r.Channel,
+		r.RunMode, r.Parameters, r.Status, r.ErrorMessage, r.Snapshot, r.IP, r.Server, r.QueuedTime, r.StartTime, r.StopTime)
+	if err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+	}
+}
+
+func (db *ScriptDb) FinishRun(r *RunRecord) {
+	sql := `update merkaba_run set status=?,error=?,snapshot=?,stopTime=? where id=?`
+	_, err := db.Client.Update(sql, r.Status, r.ErrorMessage, r.Snapshot, r.StopTime, r.Id)
+	if err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+	}
+}
+
+// ReadMaxRunTime 脚本配置的最长运行时间(秒),保存在merkaba表的maxRunTime,0表示不限制
+func (db *ScriptDb) ReadMaxRunTime(scriptId string) int64 {
+	var values []int64
+	err := db.Client.Select(&values, "select ifnull(maxRunTime,0) from merkaba where id=?", scriptId)
+	if err != nil || len(values) == 0 {
+		return 0
+	}
+	return values[0]
+}
+
+func (db *ScriptDb) ReadRun(id string) (*RunRecord, error) {
+	sql := `select ` + runColumns + ` from merkaba_run where id=?`
+	var result RunRecord
+	err := db.Client.Get(&result, sql, id)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ClearSnapshots 运行目录被清理后,清除执行记录中已经删除的截图路径
+func (db *ScriptDb) ClearSnapshots(runIds []string) {
+	for _, runId := range runIds {
+		for _, sql := range []string{
+			`update merkaba_run set snapshot='' where id=? and snapshot<>''`,
+			`update merkaba_run_attempt set snapshot='' where runId=? and snapshot<>''`,
+		} {
+			if _, err := db.Client.Update(sql, runId); err != nil {
+				common.LoggerStd.Error(sql, zap.Error(err))
+			}
+		}
+	}
+}
+
+func (db *ScriptDb) QueryRuns(filter RunFilter) ([]RunRecord, error) {
+	var builder strings.Builder
+	args := make([]any, 0)
+	builder.WriteString(`select ` + runColumns + ` from merkaba_run where 1=1`)
+	if len(filter.ScriptUri) > 0 {
+		builder.WriteString(" and scriptUri=?")
+		args = append(args, filter.ScriptUri)
+	}
+	if len(filter.TaskName) > 0 {
+		builder.WriteString(" and taskName=?")
+		args = append(args, filter.TaskName)
+	}
+	if len(filter.Status) > 0 {
+		builder.WriteString(" and status=?")
+		args = append(args, filter.Status)
+	}
+	if filter.From > 0 {
+		builder.WriteString(" and startTime>=?")
+		args = append(args, filter.From)
+	}
+	if filter.To > 0 {
+		builder.WriteString(" and startTime<?")
+		args = append(args, filter.To)
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	builder.WriteString(" order by startTime desc limit ? offset ?")
+	args = append(args, limit, filter.Offset)
+	result := make([]RunRecord, 0)
+	err := db.Client.Select(&result, builder.String(), args...)
+	return result, err
+}
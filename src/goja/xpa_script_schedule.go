@@ -0,0 +1,123 @@
+package goja
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"merkaba/common"
+)
+
+const (
+	OverlapSkip    = "skip"
+	OverlapQueue   = "queue"
+	OverlapReplace = "replace"
+)
+
+/*定时任务一次触发的结果,执行结果见merkaba_run*/
+const (
+	ScheduleStatusSkipped = "Skipped"
+	ScheduleStatusFailed  = "Failed"
+)
+
+// ScheduleRecord 节点上的定时任务,保存在merkaba_schedule表
+type ScheduleRecord struct {
+	Id           string `db:"id"`
+	IP           string `db:"ip"`
+	Name         string `db:"name"`
+	Cron         string `db:"cron"`
+	Timezone     string `db:"timezone"`
+	ScriptId     string `db:"scriptId"`
+	ScriptUri    string `db:"scriptUri"`
+	TaskName     string `db:"taskName"`
+	Parameters   string `db:"parameters"`
+	Overlap      string `db:"overlap"`
+	Enabled      bool   `db:"enabled"`
+	CreatedTime  int64  `db:"createdTime"`
+	UpdatedTime  int64  `db:"updatedTime"`
+	LastFireTime int64  `db:"lastFireTime"`
+	LastRunId    string `db:"lastRunId"`
+	LastStatus   string `db:"lastStatus"`
+	LastError    string `db:"lastError"`
+}
+
+const scheduleColumns = `id,ip,name,cron,timezone,scriptId,scriptUri,taskName,parameters,overlap,enabled,createdTime,updatedTime,lastFireTime,lastRunId,lastStatus,ifnull(lastError,'') as lastError`
+
+func (r *ScheduleRecord) ParameterMap() map[string]any {
+	var parameters map[string]any
+	json.Unmarshal([]byte(r.Parameters), &parameters)
+	return parameters
+}
+
+func (r *ScheduleRecord) AsMap() map[string]any {
+	data := make(map[string]any)
+	data["id"] = r.Id
+	data["ip"] = r.IP
+	data["name"] = r.Name
+	data["cron"] = r.Cron
+	data["timezone"] = r.Timezone
+	data["scriptId"] = r.ScriptId
+	data["scriptUri"] = r.ScriptUri
+	data["taskName"] = r.TaskName
+	data["parameters"] = r.ParameterMap()
+	data["overlap"] = r.Overlap
+	data["enabled"] = r.Enabled
+	data["createdTime"] = r.CreatedTime
+	data["updatedTime"] = r.UpdatedTime
+	data["lastFireTime"] = r.LastFireTime
+	data["lastRunId"] = r.LastRunId
+	data["lastStatus"] = r.LastStatus
+	data["lastError"] = r.LastError
+	return data
+}
+
+func (db *ScriptDb) SaveSchedule(r *ScheduleRecord) error {
+	sql := `
+            insert into merkaba_schedule(id,ip,name,cron,timezone,scriptId,scriptUri,taskName,parameters,overlap,enabled,createdTime,updatedTime)
+            values(?,?,?,?,?,?,?,?,?,?,?,?,?)
+            on duplicate key update
+                name=values(name),cron=values(cron),timezone=values(timezone),scriptId=values(scriptId),
+                scriptUri=values(scriptUri),taskName=values(taskName),parameters=values(parameters),
+                overlap=values(overlap),enabled=values(enabled),updatedTime=values(updatedTime)
+	`
+	_, err := db.Client.Update(sql, r.Id, r.IP, r.Name, r.Cron, r.Timezone, r.ScriptId, r.ScriptUri, r.TaskName,
+		r.Parameters, r.Overlap, r.Enabled, r.CreatedTime, r.UpdatedTime)
+	if err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+	}
+	return err
+}
+
+func (db *ScriptDb) DeleteSchedule(id string) error {
+	sql := `delete from merkaba_schedule where id=? and ip=?`
+	_, err := db.Client.Update(sql, id, common.LocalIP)
+	if err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+	}
+	return err
+}
+
+// FinishSchedule 记录定时任务最近一次触发的结果
+func (db *ScriptDb) FinishSchedule(r *ScheduleRecord) {
+	sql := `update merkaba_schedule set lastFireTime=?,lastRunId=?,lastStatus=?,lastError=? where id=?`
+	_, err := db.Client.Update(sql, r.LastFireTime, r.LastRunId, r.LastStatus, r.LastError, r.Id)
+	if err != nil {
+		common.LoggerStd.Error(sql, zap.Error(err))
+	}
+}
+
+func (db *ScriptDb) ReadSchedule(id string) (*ScheduleRecord, error) {
+	sql := `select ` + scheduleColumns + ` from merkaba_schedule where id=? and ip=?`
+	var result ScheduleRecord
+	err := db.Client.Get(&result, sql, id, common.LocalIP)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ReadSchedules 读取本节点的所有定时任务
+func (db *ScriptDb) ReadSchedules() ([]ScheduleRecord, error) {
+	sql := `select ` + scheduleColumns + ` from merkaba_schedule where ip=? order by createdTime`
+	result := make([]ScheduleRecord, 0)
+	err := db.Client.Select(&result, sql, common.LocalIP)
+	return result, err
+}
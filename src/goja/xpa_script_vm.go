@@ -21,11 +21,14 @@ func (v *ScriptVM) Init(instance *ScriptInstance) error {
 	v.Runtime = New()
 	v.Runtime.ScriptHandler = instance
 	v.Runtime.Context = ctx
+	v.Runtime.SetPauseGate(&ctx.Pause)
 	v.Command = make(chan DebugCommand, 1)
 	printer := PrinterFunc(func(level string, s string) {
 		isBrowser := ctx.RunMode == common.RunModeBrowserRun
 		if isBrowser {
 			common.SendMessage(level, ctx, s)
+		} else {
+			common.PublishMessage(level, ctx, s)
 		}
 		switch level {
 		case "warn":
@@ -42,6 +45,10 @@ func (v *ScriptVM) Init(instance *ScriptInstance) error {
 
 	InitConsole(v.Runtime, printer)
 	v.Registry = NewRegistry(WithGlobalFolders("."), WithLoader(func(url string) ([]byte, error) {
+		/*runInline提交的模块源码优先*/
+		if content, ok := instance.Modules[url[1:]]; ok {
+			return []byte(content), nil
+		}
 		content, _ := instance.DB.ReadScriptByUri(url[1:])
 		if len(content) > 0 {
 			return []byte(content), nil
@@ -53,16 +60,24 @@ func (v *ScriptVM) Init(instance *ScriptInstance) error {
 	return nil
 }
 
-// Clear todo michael xiao 需要优化，仅仅删除更新的脚本
+// Clear 清除模块,下次require时重新读取源码,编译结果由Programs按内容hash复用
 func (v *ScriptVM) Clear() {
 	v.Runtime.ClearInterrupt()
 	v.RequireModule.clear()
 	v.Registry.clear()
 }
-func (v *ScriptVM) Snapshot(fileName string) {
-	if v.Runtime.WebClient != nil {
-		v.Runtime.WebClient.Snapshot(fileName + ".jpg")
+
+// Snapshot 保存当前页面的截图,返回截图文件名,没有截图时返回空
+func (v *ScriptVM) Snapshot(fileName string) string {
+	if v.Runtime.WebClient == nil {
+		return ""
+	}
+	fileName = fileName + ".jpg"
+	v.Runtime.WebClient.Snapshot(fileName)
+	if !common.CheckFileExist(fileName) {
+		return ""
 	}
+	return fileName
 }
 
 func (v *ScriptVM) CloseWebClients() {
@@ -6,14 +6,25 @@ import (
 	"merkaba/common/queue"
 	"merkaba/goja"
 	"merkaba/server"
+	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 )
 
 var db goja.ScriptDb
 
 func main() {
 	common.InitEnviroment()
-	db = goja.ScriptDb{Client: common.Mysql}
+	store, err := common.NewScriptStore(common.Env, common.Mysql)
+	if err != nil {
+		panic(err.Error())
+	}
+	nodes, err := common.NewNodeRegistry(common.Env, common.Mysql)
+	if err != nil {
+		panic(err.Error())
+	}
+	db = goja.ScriptDb{Client: common.Mysql, Store: store, Nodes: nodes}
 	db.Init()
 	defer func() {
 		// 发生宕机时，获取panic传递的上下文并打印
@@ -24,12 +35,22 @@ func main() {
 			common.LoggerStd.Error("merkaba crash", zap.Error(err.(error)))
 		}
 	}()
-	server.Queue = queue.NewQueue(8)
+	common.Consul.RegisterMerkaba()
+	/*之后由HttpServer定期发送心跳,登记失败时心跳会重新登记*/
+	db.RegisterMerkabaNode()
+	/*worker数量按merkaba_node.maxCount,运行中由HttpServer定期刷新*/
+	node, _ := db.ReadLocalMerkabaNode()
+	server.Queue = queue.NewQueue(server.QueueWorkers(node))
+	server.Queue.SetMaxPending(common.Env.Queue.MaxPending)
 	server.Queue.Start()
 	defer server.Queue.Stop()
-	common.Consul.RegisterMerkaba()
-	for i := 0; i < 100; i++ {
-		db.RegisterMerkabaNode()
-	}
-	server.NewHttpServer(db).Start()
+	httpServer := server.NewHttpServer(db)
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		common.LoggerStd.Info("merkaba receive signal", zap.String("signal", sig.String()))
+		httpServer.Drain()
+	}()
+	httpServer.Start()
 }
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"go.uber.org/zap"
 	"merkaba/common"
+	"merkaba/common/metrics"
 	"strconv"
 	"strings"
 	"time"
@@ -67,7 +68,11 @@ func (client *PaasClient) Invoke(methodName string, params map[string]any, strea
 	return client.DoInvoke(methodName, params, stream, 2*time.Minute)
 }
 
-func (client *PaasClient) DoInvoke(methodName string, params map[string]any, stream []byte, timeOut time.Duration) (any, error) {
+func (client *PaasClient) DoInvoke(methodName string, params map[string]any, stream []byte, timeOut time.Duration) (result any, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveInvoke(client.serviceName, methodName, start, err)
+	}()
 	if client.connect == nil {
 		return nil, errors.New(client.serverIP + " connect is null")
 	}
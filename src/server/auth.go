@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"io"
+	"merkaba/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeRun     = "run"
+	ScopeDebug   = "debug"
+	ScopeVNC     = "vnc"
+	ScopeDev     = "dev"
+	ScopeAdmin   = "admin"
+	ScopeMetrics = "metrics"
+)
+
+const (
+	headerClient    = "X-Merkaba-Client"
+	headerTimestamp = "X-Merkaba-Timestamp"
+	headerSignature = "X-Merkaba-Signature"
+)
+
+/*接口鉴权,支持Bearer token和HMAC签名两种方式*/
+type authenticator struct {
+	enabled bool
+	maxSkew int64
+	byToken map[string]*common.AuthToken
+	byName  map[string]*common.AuthToken
+}
+
+func newAuthenticator(env *common.YamlFile) *authenticator {
+	a := &authenticator{
+		enabled: env.Auth.Enabled,
+		maxSkew: env.Auth.MaxSkew,
+		byToken: make(map[string]*common.AuthToken),
+		byName:  make(map[string]*common.AuthToken),
+	}
+	if a.maxSkew <= 0 {
+		a.maxSkew = 300
+	}
+	if !a.enabled {
+		return a
+	}
+	tokens := env.Auth.Tokens
+	if env.Auth.Consul {
+		tokens = append(tokens, common.Consul.ReadAuthTokens()...)
+	}
+	for i := range tokens {
+		token := &tokens[i]
+		if len(token.Token) > 0 {
+			a.byToken[token.Token] = token
+		}
+		if len(token.Secret) > 0 {
+			a.byName[token.Name] = token
+		}
+	}
+	common.LoggerStd.Info("auth enabled", zap.Int("tokens", len(tokens)))
+	return a
+}
+
+func (a *authenticator) authenticate(c *gin.Context) (*common.AuthToken, error) {
+	if name := c.GetHeader(headerClient); len(name) > 0 {
+		return a.verifySignature(c, name)
+	}
+	var value string
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		value = strings.TrimPrefix(header, "Bearer ")
+	} else {
+		/*<img>和EventSource无法设置header,允许通过参数传递*/
+		value = c.Query("access_token")
+	}
+	return a.verifyToken(value)
+}
+
+func (a *authenticator) verifyToken(value string) (*common.AuthToken, error) {
+	if len(value) == 0 {
+		return nil, errors.New("missing credentials")
+	}
+	token, ok := a.byToken[value]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return token, nil
+}
+
+// verifySignature 签名 = hex(HMAC-SHA256(secret, method + "\n" + uri + "\n" + timestamp + "\n" + body))
+func (a *authenticator) verifySignature(c *gin.Context, name string) (*common.AuthToken, error) {
+	token, ok := a.byName[name]
+	if !ok {
+		return nil, errors.New("unknown client " + name)
+	}
+	timestamp := c.GetHeader(headerTimestamp)
+	second, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid timestamp")
+	}
+	if skew := time.Now().Unix() - second; skew > a.maxSkew || skew < -a.maxSkew {
+		return nil, errors.New("timestamp expired")
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	mac := hmac.New(sha256.New, []byte(token.Secret))
+	mac.Write([]byte(c.Request.Method + "\n" + c.Request.URL.RequestURI() + "\n" + timestamp + "\n"))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	signature, err := hex.DecodeString(c.GetHeader(headerSignature))
+	if err != nil || !hmac.Equal(signature, expected) {
+		return nil, errors.New("invalid signature")
+	}
+	return token, nil
+}
+
+// authorize 检查调用者是否拥有路由需要的scope
+func (server *HttpServer) authorize(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a := server.authenticator
+		if !a.enabled {
+			c.Next()
+			return
+		}
+		token, err := a.authenticate(c)
+		if err != nil {
+			common.LoggerStd.Warn("reject request", zap.String("path", c.FullPath()),
+				zap.String("remote", c.ClientIP()), zap.Error(err))
+			c.AbortWithStatusJSON(ErrUnauthorized.Status(), errorResp(ErrUnauthorized, err.Error()))
+			return
+		}
+		if !token.HasScope(scope) {
+			common.LoggerStd.Warn("reject request", zap.String("path", c.FullPath()),
+				zap.String("remote", c.ClientIP()), zap.String("client", token.Name), zap.String("scope", scope))
+			c.AbortWithStatusJSON(ErrForbidden.Status(), errorResp(ErrForbidden, "scope "+scope+" required"))
+			return
+		}
+		c.Set("client", token.Name)
+		c.Next()
+	}
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"merkaba/common/queue"
+	"merkaba/goja"
+	"sync"
+	"time"
+)
+
+const (
+	BatchItemPending   = "pending"
+	BatchItemRunning   = "running"
+	BatchItemSucceeded = "succeeded"
+	BatchItemFailed    = "failed"
+	BatchItemCancelled = "cancelled"
+)
+
+const (
+	defaultBatchConcurrency = 8
+	/*结束后的批次在内存中保留的时间*/
+	batchRetention = time.Hour
+	/*容量不足时再次提交的间隔*/
+	batchWaitInterval = time.Second
+)
+
+type batchItem struct {
+	Index      int
+	TaskName   string
+	RunId      string
+	Parameters map[string]any
+	Status     string
+	Error      string
+	StartTime  int64
+	StopTime   int64
+}
+
+func (item *batchItem) AsMap() map[string]any {
+	data := make(map[string]any)
+	data["index"] = item.Index
+	data["taskName"] = item.TaskName
+	data["runId"] = item.RunId
+	data["parameters"] = item.Parameters
+	data["status"] = item.Status
+	data["error"] = item.Error
+	data["startTime"] = item.StartTime
+	data["stopTime"] = item.StopTime
+	return data
+}
+
+// Batch 同一个脚本按多组参数批量执行,同时运行的数量不超过Concurrency
+type Batch struct {
+	Id          string
+	ScriptId    string
+	ScriptUri   string
+	Concurrency int
+	CreatedTime int64
+	StopTime    int64
+	lock        sync.Mutex
+	items       []*batchItem
+	cancelled   bool
+}
+
+func (b *Batch) AsMap(includeItems bool) map[string]any {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	counts := map[string]int{
+		BatchItemPending:   0,
+		BatchItemRunning:   0,
+		BatchItemSucceeded: 0,
+		BatchItemFailed:    0,
+		BatchItemCancelled: 0,
+	}
+	items := make([]any, 0, len(b.items))
+	for _, item := range b.items {
+		counts[item.Status] += 1
+		if includeItems {
+			items = append(items, item.AsMap())
+		}
+	}
+	data := make(map[string]any)
+	data["batchId"] = b.Id
+	data["scriptId"] = b.ScriptId
+	data["scriptUri"] = b.ScriptUri
+	data["concurrency"] = b.Concurrency
+	data["createdTime"] = b.CreatedTime
+	data["stopTime"] = b.StopTime
+	data["cancelled"] = b.cancelled
+	data["total"] = len(b.items)
+	for status, count := range counts {
+		data[status] = count
+	}
+	if includeItems {
+		data["items"] = items
+	}
+	return data
+}
+
+func (b *Batch) isCancelled() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.cancelled
+}
+
+func (b *Batch) setStatus(item *batchItem, status string, message string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	item.Status = status
+	item.Error = message
+	item.StopTime = time.Now().UnixMilli()
+}
+
+/*进程内的批次列表*/
+type batchManager struct {
+	lock    sync.RWMutex
+	batches map[string]*Batch
+}
+
+func newBatchManager() *batchManager {
+	return &batchManager{batches: make(map[string]*Batch)}
+}
+
+func (m *batchManager) find(id string) *Batch {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.batches[id]
+}
+
+func (m *batchManager) add(b *Batch) {
+	m.lock.Lock()
+	m.batches[b.Id] = b
+	m.lock.Unlock()
+}
+
+func (m *batchManager) remove(id string) {
+	m.lock.Lock()
+	delete(m.batches, id)
+	m.lock.Unlock()
+}
+
+// newBatch 为每组参数生成taskName,参数在parameters的基础上覆盖
+func newBatch(req *RunBatchRequest) *Batch {
+	b := &Batch{
+		Id:          primitive.NewObjectID().Hex(),
+		ScriptId:    req.ScriptId,
+		ScriptUri:   req.ScriptUri,
+		Concurrency: req.Concurrency,
+		CreatedTime: time.Now().UnixMilli(),
+		items:       make([]*batchItem, 0, len(req.Items)),
+	}
+	if b.Concurrency <= 0 {
+		b.Concurrency = defaultBatchConcurrency
+	}
+	prefix := req.TaskPrefix
+	if len(prefix) == 0 {
+		prefix = b.Id
+	}
+	for i, values := range req.Items {
+		parameters := make(map[string]any)
+		for k, v := range req.Parameters {
+			parameters[k] = v
+		}
+		for k, v := range values {
+			parameters[k] = v
+		}
+		b.items = append(b.items, &batchItem{
+			Index:      i,
+			TaskName:   fmt.Sprintf("%s_%d", prefix, i),
+			Parameters: parameters,
+			Status:     BatchItemPending,
+		})
+	}
+	return b
+}
+
+// runBatch 按并发数依次把任务提交到执行队列,任务结束后释放实例
+func (server *HttpServer) runBatch(b *Batch, req *RunBatchRequest) {
+	slots := make(chan struct{}, b.Concurrency)
+	var wg sync.WaitGroup
+	for _, item := range b.items {
+		slots <- struct{}{}
+		b.lock.Lock()
+		cancelled := b.cancelled
+		b.lock.Unlock()
+		if cancelled {
+			<-slots
+			break
+		}
+		wg.Add(1)
+		go func(item *batchItem) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			server.runBatchItem(b, item, req)
+		}(item)
+	}
+	wg.Wait()
+	b.lock.Lock()
+	for _, item := range b.items {
+		if item.Status == BatchItemPending {
+			item.Status = BatchItemCancelled
+		}
+	}
+	b.StopTime = time.Now().UnixMilli()
+	b.lock.Unlock()
+	common.LoggerStd.Info("batch finished", zap.String("batchId", b.Id), zap.String("scriptUri", b.ScriptUri))
+	time.AfterFunc(batchRetention, func() {
+		server.batches.remove(b.Id)
+	})
+}
+
+func (server *HttpServer) runBatchItem(b *Batch, item *batchItem, req *RunBatchRequest) {
+	if b.isCancelled() {
+		return
+	}
+	/*只回收这个任务创建的实例,已经存在的空闲实例执行后保留*/
+	existing := server.DB.FindMemInstance(item.TaskName)
+	instance, err := server.submitBatchItem(b, item, req)
+	if err != nil {
+		b.setStatus(item, BatchItemFailed, err.Message)
+		return
+	}
+	if instance == nil {
+		return
+	}
+	b.lock.Lock()
+	item.Status = BatchItemRunning
+	item.StartTime = time.Now().UnixMilli()
+	item.RunId = instance.Context.RunId
+	cancelled := b.cancelled
+	b.lock.Unlock()
+	if cancelled {
+		/*提交的同时批次被取消了*/
+		server.stopScript(&TaskRequest{TaskName: item.TaskName})
+	}
+	<-instance.Done()
+	switch {
+	case instance.IsSuccess:
+		b.setStatus(item, BatchItemSucceeded, "")
+	case b.isCancelled():
+		b.setStatus(item, BatchItemCancelled, instance.ErrorMessage)
+	default:
+		b.setStatus(item, BatchItemFailed, instance.ErrorMessage)
+	}
+	/*批量任务的实例只执行一次,结束后释放VM和浏览器;已经被新的请求使用时不回收*/
+	if instance != existing {
+		server.DB.EvictMemInstance(instance, goja.EvictOneShot)
+	}
+}
+
+// submitBatchItem 实例数或者队列已满时等待,直到提交成功;批次被取消时返回nil
+func (server *HttpServer) submitBatchItem(b *Batch, item *batchItem, req *RunBatchRequest) (*goja.ScriptInstance, *apiError) {
+	for {
+		instance, err := server.submitScript(&RunScriptRequest{
+			ScriptId:      req.ScriptId,
+			ScriptUri:     req.ScriptUri,
+			TaskName:      item.TaskName,
+			Parameters:    item.Parameters,
+			CookieId:      req.CookieId,
+			AppServerIP:   req.AppServerIP,
+			AppServerPort: req.AppServerPort,
+			MaxWaitTime:   req.MaxWaitTime,
+			RunMode:       req.RunMode,
+			Priority:      queue.PriorityLow,
+		})
+		if err == nil || (err.Code != ErrCapacityExceeded && err.Code != ErrQueueFull) {
+			return instance, err
+		}
+		time.Sleep(batchWaitInterval)
+		if b.isCancelled() {
+			return nil, nil
+		}
+	}
+}
+
+// cancelBatch 停止批次,未开始的任务不再执行,运行中的任务被终止
+func (server *HttpServer) cancelBatch(b *Batch) {
+	b.lock.Lock()
+	b.cancelled = true
+	running := make([]string, 0)
+	for _, item := range b.items {
+		switch item.Status {
+		case BatchItemPending:
+			item.Status = BatchItemCancelled
+			item.StopTime = time.Now().UnixMilli()
+		case BatchItemRunning:
+			running = append(running, item.TaskName)
+		}
+	}
+	b.lock.Unlock()
+	for _, taskName := range running {
+		server.stopScript(&TaskRequest{TaskName: taskName})
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"github.com/shirou/gopsutil/v3/mem"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"merkaba/goja"
+	"time"
+)
+
+const defaultEvictInterval = 30
+
+/*内存不足时每回收一个实例后等待浏览器进程退出,再重新检查*/
+const evictMemoryPause = 500 * time.Millisecond
+
+// evictIdle 定期回收空闲的实例和浏览器,直到节点下线
+func (server *HttpServer) evictIdle() {
+	interval := common.Env.Evict.Interval
+	if interval <= 0 {
+		interval = defaultEvictInterval
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			server.evict()
+		case <-server.drained:
+			return
+		}
+	}
+}
+
+func (server *HttpServer) evict() {
+	policy := goja.EvictPolicy{
+		IdleTTL: time.Duration(common.Env.Evict.IdleTTL) * time.Second,
+		MaxIdle: common.Env.Evict.MaxIdle,
+	}
+	if count := server.DB.Evict(policy); count > 0 {
+		common.LoggerStd.Info("evict idle instances", zap.Int("count", count))
+	}
+	minFree := common.Env.Evict.MinFreeMemory
+	if minFree == 0 {
+		return
+	}
+	for {
+		available, ok := availableMemory()
+		if !ok || available >= minFree {
+			return
+		}
+		common.LoggerStd.Warn("memory pressure", zap.Uint64("availableMB", available), zap.Uint64("minFreeMB", minFree))
+		if !server.DB.EvictOldest() {
+			return
+		}
+		time.Sleep(evictMemoryPause)
+	}
+}
+
+// availableMemory 可用内存(MB)
+func availableMemory() (uint64, bool) {
+	v, err := mem.VirtualMemory()
+	if err != nil {
+		common.LoggerStd.Error("read memory", zap.Error(err))
+		return 0, false
+	}
+	return v.Available / 1024 / 1024, true
+}
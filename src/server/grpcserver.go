@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"merkaba/common"
+	"merkaba/rpc"
+	"net"
+	"strings"
+	"time"
+)
+
+const grpcServiceName = "merkaba"
+
+/*AgentResponse.dataType,和paas.factory.go中的解析一致,17表示map*/
+const grpcDataTypeMap = 17
+
+type grpcHandler struct {
+	scope string
+	call  func(agent *rpc.AgentContext, params []byte) (gin.H, *apiError)
+}
+
+// GrpcServer 以GrpcAgentService的方式提供和http相同的接口,供java,python服务调用
+type GrpcServer struct {
+	rpc.UnimplementedGrpcAgentServiceServer
+	server   *HttpServer
+	instance *grpc.Server
+	handlers map[string]grpcHandler
+}
+
+/*解析并校验参数,校验规则和http接口相同*/
+func grpcMethod[T any](scope string, fn func(agent *rpc.AgentContext, req *T) (gin.H, *apiError)) grpcHandler {
+	return grpcHandler{
+		scope: scope,
+		call: func(agent *rpc.AgentContext, params []byte) (gin.H, *apiError) {
+			var req T
+			if len(params) > 0 {
+				if err := json.Unmarshal(params, &req); err != nil {
+					return nil, newApiError(ErrInvalidRequest, "data format error: "+err.Error())
+				}
+			}
+			if err := binding.Validator.ValidateStruct(&req); err != nil {
+				return nil, newApiError(ErrInvalidRequest, bindMessage(err))
+			}
+			return fn(agent, &req)
+		},
+	}
+}
+
+func newGrpcServer(server *HttpServer) *GrpcServer {
+	g := &GrpcServer{
+		server:   server,
+		instance: grpc.NewServer(),
+	}
+	g.handlers = map[string]grpcHandler{
+		"runScript": grpcMethod(ScopeRun, func(agent *rpc.AgentContext, req *RunScriptRequest) (gin.H, *apiError) {
+			if len(req.CookieId) == 0 {
+				req.CookieId = agent.GetCookieId()
+			}
+			return server.runScript(req)
+		}),
+		"stopScript": grpcMethod(ScopeRun, func(agent *rpc.AgentContext, req *TaskRequest) (gin.H, *apiError) {
+			return server.stopScript(req), nil
+		}),
+		"pauseScript": grpcMethod(ScopeRun, func(agent *rpc.AgentContext, req *TaskRequest) (gin.H, *apiError) {
+			return server.pauseScript(req)
+		}),
+		"resumeScript": grpcMethod(ScopeRun, func(agent *rpc.AgentContext, req *TaskRequest) (gin.H, *apiError) {
+			return server.resumeScript(req)
+		}),
+		"debugScript": grpcMethod(ScopeDebug, func(agent *rpc.AgentContext, req *DebugScriptRequest) (gin.H, *apiError) {
+			return server.debugScript(req)
+		}),
+		"watchScript": grpcMethod(ScopeDebug, func(agent *rpc.AgentContext, req *WatchScriptRequest) (gin.H, *apiError) {
+			return server.watchScript(req)
+		}),
+		"readScriptCount": grpcMethod(ScopeRun, func(agent *rpc.AgentContext, req *ReadScriptCountRequest) (gin.H, *apiError) {
+			return server.readScriptCount(req), nil
+		}),
+		"readScriptInstance": grpcMethod(ScopeRun, func(agent *rpc.AgentContext, req *ReadScriptInstanceRequest) (gin.H, *apiError) {
+			return server.readScriptInstance(req), nil
+		}),
+		"startVNC": grpcMethod(ScopeVNC, func(agent *rpc.AgentContext, req *TaskRequest) (gin.H, *apiError) {
+			return server.startVNC(req)
+		}),
+		"stopVNC": grpcMethod(ScopeVNC, func(agent *rpc.AgentContext, req *TaskRequest) (gin.H, *apiError) {
+			return server.stopVNC(req), nil
+		}),
+	}
+	rpc.RegisterGrpcAgentServiceServer(g.instance, g)
+	return g
+}
+
+// Invoke 按serviceName和methodName分发请求,业务错误通过isSuccess=false返回,不使用grpc的错误码
+func (g *GrpcServer) Invoke(ctx context.Context, request *rpc.AgentRequest) (*rpc.AgentResponse, error) {
+	agent := request.GetCtx()
+	fields := []zap.Field{
+		zap.String("service", request.GetServiceName()),
+		zap.String("method", request.GetMethodName()),
+		zap.String("traceId", agent.GetTraceId()),
+		zap.String("cookieId", agent.GetCookieId()),
+		zap.String("server", agent.GetServer()),
+	}
+	data, err := g.dispatch(ctx, request)
+	if err != nil {
+		common.LoggerStd.Warn("grpc invoke: "+err.Message, append(fields, zap.String("code", string(err.Code)))...)
+		return g.response(false, errorResp(err.Code, err.Message)), nil
+	}
+	common.LoggerStd.Info("grpc invoke", fields...)
+	return g.response(true, data), nil
+}
+
+func (g *GrpcServer) dispatch(ctx context.Context, request *rpc.AgentRequest) (gin.H, *apiError) {
+	if !strings.EqualFold(request.GetServiceName(), grpcServiceName) {
+		return nil, newApiError(ErrInvalidRequest, "unknown service "+request.GetServiceName())
+	}
+	handler, ok := g.handlers[request.GetMethodName()]
+	if !ok {
+		return nil, newApiError(ErrInvalidRequest, "unknown method "+request.GetMethodName())
+	}
+	if err := g.authorize(ctx, handler.scope); err != nil {
+		return nil, err
+	}
+	return handler.call(request.GetCtx(), request.GetParams())
+}
+
+/*grpc只支持Bearer token,通过metadata的authorization传递*/
+func (g *GrpcServer) authorize(ctx context.Context, scope string) *apiError {
+	a := g.server.authenticator
+	if !a.enabled {
+		return nil
+	}
+	var value string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			value = strings.TrimPrefix(values[0], "Bearer ")
+		}
+	}
+	remote := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+	token, err := a.verifyToken(value)
+	if err != nil {
+		common.LoggerStd.Warn("reject grpc request", zap.String("remote", remote), zap.Error(err))
+		return newApiError(ErrUnauthorized, err.Error())
+	}
+	if !token.HasScope(scope) {
+		common.LoggerStd.Warn("reject grpc request", zap.String("remote", remote),
+			zap.String("client", token.Name), zap.String("scope", scope))
+		return newApiError(ErrForbidden, "scope "+scope+" required")
+	}
+	return nil
+}
+
+func (g *GrpcServer) response(isSuccess bool, data gin.H) *rpc.AgentResponse {
+	body, _ := json.Marshal(data)
+	return &rpc.AgentResponse{
+		IsSuccess:  isSuccess,
+		ServerName: common.LocalName,
+		Timestamp:  time.Now().UnixMilli(),
+		DataType:   grpcDataTypeMap,
+		DataBody:   string(body),
+	}
+}
+
+func (g *GrpcServer) Start(listen string, port int) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", listen, port))
+	if err != nil {
+		return err
+	}
+	common.LoggerStd.Info("grpc server start", zap.Int("port", port))
+	go g.instance.Serve(lis)
+	return nil
+}
+
+func (g *GrpcServer) Stop() {
+	g.instance.GracefulStop()
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"go.uber.org/zap"
+	"merkaba/common"
+	"merkaba/goja"
+	"time"
+)
+
+// heartbeat 定期上报本节点的负载,同时把心跳超时的节点标记为下线,直到节点下线
+func (server *HttpServer) heartbeat() {
+	ticker := time.NewTicker(common.NodeHeartbeat())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			server.beat()
+		case <-server.beatNow:
+			server.beat()
+		case <-server.drained:
+			return
+		}
+	}
+}
+
+func (server *HttpServer) beat() {
+	state := goja.NodeStateUp
+	if server.draining.Load() {
+		state = goja.NodeStateDraining
+	}
+	if err := server.DB.HeartbeatMerkabaNode(state); err != nil {
+		common.LoggerStd.Warn("node heartbeat", zap.Error(err))
+	}
+	if count, err := server.DB.ExpireMerkabaNodes(); err == nil && count > 0 {
+		common.LoggerStd.Warn("expire nodes without heartbeat", zap.Int("count", count))
+	}
+}
+
+// requestBeat 实例数量变化后尽快上报,多次请求合并为一次心跳
+func (server *HttpServer) requestBeat() {
+	select {
+	case server.beatNow <- struct{}{}:
+	default:
+	}
+}
@@ -8,26 +8,66 @@ import (
 	"merkaba/common/queue"
 	"merkaba/goja"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var Queue *queue.Queue
 
 type HttpServer struct {
-	instance *gin.Engine
-	DB       goja.ScriptDb
+	instance      *gin.Engine
+	httpServer    *http.Server
+	grpcServer    *GrpcServer
+	authenticator *authenticator
+	draining      atomic.Bool
+	drainOnce     sync.Once
+	drained       chan struct{}
+	beatNow       chan struct{}
+	batches       *batchManager
+	scheduler     *scheduler
+	journal       *journal
+	node          atomic.Pointer[goja.MerkabaNode]
+	DB            goja.ScriptDb
 }
 
 func NewHttpServer(db goja.ScriptDb) *HttpServer {
 	r := gin.New()
 	r.Use(gin.Recovery())
 	result := &HttpServer{
-		instance: r,
-		DB:       db,
+		instance:      r,
+		authenticator: newAuthenticator(common.Env),
+		drained:       make(chan struct{}),
+		beatNow:       make(chan struct{}, 1),
+		batches:       newBatchManager(),
+		DB:            db,
+	}
+	result.scheduler = newScheduler(result)
+	result.DB.OnEvict = result.requestBeat
+	result.refreshNode()
+	if j, err := openJournal(journalPath()); err != nil {
+		common.LoggerStd.Error("open journal", zap.String("path", journalPath()), zap.Error(err))
+	} else {
+		result.journal = j
+		result.DB.Journal = j
+	}
+	result.httpServer = &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", listenHost(), common.LocalPort),
+		Handler: r,
+	}
+	if common.Env.Server.GrpcPort > 0 {
+		result.grpcServer = newGrpcServer(result)
 	}
 	return result
 }
 
+func listenHost() string {
+	if len(common.Env.Server.Listen) == 0 {
+		return "0.0.0.0"
+	}
+	return common.Env.Server.Listen
+}
+
 func successResp() gin.H {
 	return gin.H{
 		"serverName": common.LocalName,
@@ -36,89 +76,140 @@ func successResp() gin.H {
 	}
 }
 
-func errorResp(message string) gin.H {
+func errorResp(code ErrorCode, message string) gin.H {
 	return gin.H{
 		"serverName": common.LocalName,
 		"serverIP":   common.LocalIP,
 		"isSuccess":  false,
+		"code":       code,
 		"message":    message,
 	}
 }
 
-func (server *HttpServer) parseData(c *gin.Context) map[string]interface{} {
-	var data map[string]interface{}
-	err := c.ShouldBindJSON(&data)
-	if err != nil {
-		common.LoggerStd.Error(err.Error())
-		return nil
-	}
-	return data
-}
-
-func (server *HttpServer) buildScriptInstance(c *gin.Context, localNode *goja.MerkabaNode, siteName string,
+func (server *HttpServer) buildScriptInstance(siteName string,
 	scriptId string, scriptUri string, scriptVersion string, scriptContent string, parameters map[string]any,
-	taskName string) *goja.ScriptInstance {
-	instance := server.DB.FindMemInstance(taskName)
-	if instance == nil {
-		common.LoggerStd.Info("创建新的VM", zap.String("taskName", taskName))
-		runCount, idleCount := server.DB.ReadInstanceCount()
-		if localNode.MaxCount > 0 && (runCount+idleCount) > localNode.MaxCount {
-			server.writeResponse(c, errorResp(fmt.Sprintf("can't lanuch new instance,exceed %d", localNode.MaxCount)))
-			return nil
-		}
-		context := &common.RunContext{
-			TaskName:      taskName,
-			ScriptId:      scriptId,
-			ScriptUri:     scriptUri,
-			ScriptVersion: scriptVersion,
-			SiteName:      siteName,
+	taskName string) (*goja.ScriptInstance, *apiError) {
+	var instance *goja.ScriptInstance
+	for {
+		instance = server.DB.FindMemInstance(taskName)
+		if instance != nil && instance.Status() == goja.InstanceEvicting {
+			/*正在回收的实例不再使用,创建新的实例*/
+			server.DB.RemoveMemInstance(instance)
+			instance = nil
 		}
-		instance = &goja.ScriptInstance{
-			Context:   context,
-			DB:        &server.DB,
-			StartTime: time.Now().UnixMilli(),
-		}
-		err := instance.InitVM()
-		if err != nil {
-			server.writeResponse(c, errorResp(err.Error()))
-			return nil
-		}
-		server.DB.AddMemInstance(instance)
-	} else {
-		if instance.Status == "Running" {
-			server.writeResponse(c, errorResp("实例正在运行中，请先终止运行"))
-			return nil
+		if instance == nil {
+			var err *apiError
+			instance, err = server.newScriptInstance(siteName, scriptId, scriptUri, scriptVersion, taskName)
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			common.LoggerStd.Info("使用缓存VM", zap.String("taskName", taskName))
 		}
+		/*空闲的实例才能转为等待执行,之后才能修改运行参数*/
+		if server.DB.QueueMemInstance(instance) {
+			break
+		}
+		/*查找之后被回收了,重新创建*/
+		if instance.Status() != goja.InstanceEvicting {
+			return nil, newApiError(ErrInstanceRunning, "实例正在运行中，请先终止运行")
+		}
 	}
 	/*更新实列的运行参数*/
 	instance.Context.Init(parameters)
+	instance.Context.ScriptVersion = scriptVersion
 	instance.ScriptContent = scriptContent
-	if v, ok := parameters["proxy"]; ok {
-		instance.Context.Proxy = v.(bool)
+	if v, ok := parameters["proxy"].(bool); ok {
+		instance.Context.Proxy = v
+	}
+	if v, ok := parameters["headless"].(bool); ok {
+		instance.Context.Headless = v
+	}
+	return instance, nil
+}
+
+func (server *HttpServer) newScriptInstance(siteName string,
+	scriptId string, scriptUri string, scriptVersion string, taskName string) (*goja.ScriptInstance, *apiError) {
+	common.LoggerStd.Info("创建新的VM", zap.String("taskName", taskName))
+	/*实例数不超过merkaba_node.maxCount,0表示不限制*/
+	runCount, idleCount := server.DB.ReadInstanceCount()
+	if capacity := server.localNode().MaxCount; capacity > 0 && (runCount+idleCount) > capacity {
+		return nil, newApiError(ErrCapacityExceeded, fmt.Sprintf("can't lanuch new instance,exceed %d", capacity))
+	}
+	context := &common.RunContext{
+		TaskName:      taskName,
+		ScriptId:      scriptId,
+		ScriptUri:     scriptUri,
+		ScriptVersion: scriptVersion,
+		SiteName:      siteName,
+	}
+	instance := &goja.ScriptInstance{
+		Context:   context,
+		DB:        &server.DB,
+		StartTime: time.Now().UnixMilli(),
 	}
-	if v, ok := parameters["headless"]; ok {
-		instance.Context.Headless = v.(bool)
+	err := instance.InitVM()
+	if err != nil {
+		return nil, newApiError(ErrInternal, err.Error())
+	}
+	/*同一个taskName的并发请求,只有一个能登记成功,没有登记的实例关闭浏览器后丢弃*/
+	registered, added := server.DB.AddMemInstance(instance)
+	if !added {
+		instance.RunVM.CloseWebClients()
 	}
-	return instance
+	return registered, nil
 }
 
 func (server *HttpServer) writeResponse(c *gin.Context, json gin.H) {
 	c.JSON(http.StatusOK, json)
 }
 
+// writeError 按错误码返回对应的http状态码,响应格式和成功时相同
+func (server *HttpServer) writeError(c *gin.Context, err *apiError) {
+	c.JSON(err.Code.Status(), errorResp(err.Code, err.Message))
+}
+
 func (server *HttpServer) Start() {
 	server.registerRunScript()
+	server.registerRunInline()
 	server.registerDebug()
 	server.registerInfo()
 	server.registerWatch()
 	server.registerStopScript()
+	server.registerPauseScript()
 	server.registerReadScriptCount()
 	server.registerReadScriptInstance()
 	server.registerVideo()
+	server.registerEvents()
+	server.registerRuns()
+	server.registerArtifacts()
+	server.registerBatch()
+	server.registerSchedules()
 	server.registerStartVNC()
 	server.registerStopVNC()
+	server.registerDrain()
+	server.registerWorkers()
+	server.registerRelease()
+	server.registerNodes()
+	server.registerMetrics()
+	server.replayJournal()
+	server.scheduler.start()
+	go server.cleanArtifacts()
+	go server.refreshCapacity()
+	go server.evictIdle()
+	go server.heartbeat()
+	if server.grpcServer != nil {
+		err := server.grpcServer.Start(listenHost(), common.Env.Server.GrpcPort)
+		if err != nil {
+			common.LoggerStd.Error("grpc listen", zap.Error(err))
+		}
+	}
 	common.LoggerStd.Info("🍊🍊🍊Merkaba start success", zap.String("version", "1.3.16"))
-	server.instance.Run(fmt.Sprintf("0.0.0.0:%d", common.LocalPort))
+	err := server.httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		common.LoggerStd.Error("merkaba listen", zap.Error(err))
+		return
+	}
+	/*Shutdown后ListenAndServe立即返回,需要等待下线流程结束*/
+	<-server.drained
 }
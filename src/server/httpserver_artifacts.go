@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"strings"
+	"time"
+)
+
+/*清理过期产物的间隔*/
+const artifactCleanInterval = time.Hour
+
+// registerArtifacts 查询和下载运行产生的截图,下载文件和html
+func (server *HttpServer) registerArtifacts() {
+	server.instance.GET("/artifacts", server.authorize(ScopeRun), func(c *gin.Context) {
+		var req ArtifactsRequest
+		if !server.bindQuery(c, &req) {
+			return
+		}
+		artifacts, err := common.ListArtifacts(req.RunId)
+		if err != nil {
+			server.writeError(c, artifactError(err))
+			return
+		}
+		items := make([]any, 0, len(artifacts))
+		for _, artifact := range artifacts {
+			items = append(items, artifact)
+		}
+		json := successResp()
+		json["runId"] = req.RunId
+		json["items"] = items
+		server.writeResponse(c, json)
+	})
+	server.instance.GET("/artifacts/:runId/*name", server.authorize(ScopeRun), func(c *gin.Context) {
+		/*name可以包含子目录*/
+		artifact, fileName, err := common.FindArtifact(c.Param("runId"), strings.TrimPrefix(c.Param("name"), "/"))
+		if err != nil {
+			server.writeError(c, artifactError(err))
+			return
+		}
+		c.Header("Content-Type", artifact.MimeType)
+		c.FileAttachment(fileName, artifact.Name)
+	})
+}
+
+func artifactError(err error) *apiError {
+	if errors.Is(err, common.ErrArtifactNotFound) {
+		return newApiError(ErrArtifactNotFound, err.Error())
+	}
+	return newApiError(ErrInternal, err.Error())
+}
+
+// cleanArtifacts 定期删除超过保留时间的产物,下线后停止
+func (server *HttpServer) cleanArtifacts() {
+	ticker := time.NewTicker(artifactCleanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-server.drained:
+			return
+		case <-ticker.C:
+			if removed := common.CleanArtifacts(); len(removed) > 0 {
+				common.LoggerStd.Info("clean artifacts", zap.Int("runs", len(removed)))
+				server.DB.ClearSnapshots(removed)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// registerBatch 同一个脚本按多组参数批量执行,查询进度和取消
+func (server *HttpServer) registerBatch() {
+	server.instance.POST("/runBatch", server.authorize(ScopeRun), func(c *gin.Context) {
+		var req RunBatchRequest
+		if !server.bindJSON(c, &req) {
+			return
+		}
+		if server.draining.Load() {
+			server.writeError(c, newApiError(ErrDraining, "merkaba is draining"))
+			return
+		}
+		b := newBatch(&req)
+		server.batches.add(b)
+		go server.runBatch(b, &req)
+		taskNames := make([]string, 0, len(b.items))
+		for _, item := range b.items {
+			taskNames = append(taskNames, item.TaskName)
+		}
+		json := successResp()
+		json["batchId"] = b.Id
+		json["total"] = len(b.items)
+		json["concurrency"] = b.Concurrency
+		json["taskNames"] = taskNames
+		server.writeResponse(c, json)
+	})
+	server.instance.GET("/batches/:id", server.authorize(ScopeRun), func(c *gin.Context) {
+		b := server.batches.find(c.Param("id"))
+		if b == nil {
+			server.writeError(c, newApiError(ErrBatchNotFound, "batch not exist"))
+			return
+		}
+		json := successResp()
+		json["batch"] = b.AsMap(c.Query("includeItems") != "false")
+		server.writeResponse(c, json)
+	})
+	server.instance.POST("/stopBatch", server.authorize(ScopeRun), func(c *gin.Context) {
+		var req BatchRequest
+		if !server.bindJSON(c, &req) {
+			return
+		}
+		b := server.batches.find(req.BatchId)
+		if b == nil {
+			server.writeError(c, newApiError(ErrBatchNotFound, "batch not exist"))
+			return
+		}
+		server.cancelBatch(b)
+		json := successResp()
+		json["batch"] = b.AsMap(false)
+		server.writeResponse(c, json)
+	})
+}
@@ -2,33 +2,39 @@ package server
 
 import (
 	"github.com/gin-gonic/gin"
-	"merkaba/common"
 	"merkaba/goja"
 )
 
 func (server *HttpServer) registerDebug() {
-	server.instance.POST("/debugScript", func(c *gin.Context) {
-		data := server.parseData(c)
-		if data == nil {
+	server.instance.POST("/debugScript", server.authorize(ScopeDebug), func(c *gin.Context) {
+		var req DebugScriptRequest
+		if !server.bindJSON(c, &req) {
 			return
 		}
-		command := data["command"].(string)
-		scriptId := data["scriptId"].(string)
-		taskName := data["taskName"].(string)
-		instance := server.DB.FindMemInstance(taskName)
-		if instance == nil {
-			server.writeResponse(c, errorResp("不存在"))
+		json, err := server.debugScript(&req)
+		if err != nil {
+			server.writeError(c, err)
 			return
 		}
-		m := successResp()
-		m["scriptId"] = scriptId
-		server.writeResponse(c, m)
-		dCommand := goja.DebugCommand{
-			TaskName: taskName,
-			Command:  command,
-		}
-		dCommand.Line = common.ParseIntFrom(data, "line")
-		instance.RunVM.Command <- dCommand
+		server.writeResponse(c, json)
 	})
+}
 
+func (server *HttpServer) debugScript(req *DebugScriptRequest) (gin.H, *apiError) {
+	instance := server.DB.FindMemInstance(req.TaskName)
+	if instance == nil {
+		return nil, newApiError(ErrInstanceNotFound, "不存在")
+	}
+	dCommand := goja.DebugCommand{
+		TaskName: req.TaskName,
+		Command:  req.Command,
+		Line:     -1,
+	}
+	if req.Line != nil {
+		dCommand.Line = *req.Line
+	}
+	instance.RunVM.Command <- dCommand
+	m := successResp()
+	m["scriptId"] = req.ScriptId
+	return m, nil
 }
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"merkaba/goja"
+	"time"
+)
+
+const (
+	defaultDrainTimeout = 300
+	/*强制终止后等待脚本退出的时间,之后才关闭执行日志*/
+	drainInterruptGrace = 30 * time.Second
+)
+
+func (server *HttpServer) registerDrain() {
+	server.instance.POST("/drain", server.authorize(ScopeAdmin), func(c *gin.Context) {
+		go server.Drain()
+		runCount, _ := server.DB.ReadInstanceCount()
+		json := successResp()
+		json["draining"] = true
+		json["runCount"] = runCount
+		server.writeResponse(c, json)
+	})
+}
+
+// Drain 节点下线:不再接收新的脚本,从consul注销,等待运行中的脚本结束,超时后强制终止,最后关闭http服务
+func (server *HttpServer) Drain() {
+	server.drainOnce.Do(func() {
+		defer close(server.drained)
+		server.draining.Store(true)
+		common.LoggerStd.Info("merkaba draining")
+		server.scheduler.stop()
+		common.Consul.DeregisterMerkaba()
+		server.DB.DrainMerkabaNode()
+
+		timeout := common.Env.Server.DrainTimeout
+		if timeout <= 0 {
+			timeout = defaultDrainTimeout
+		}
+		deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+		for {
+			running := server.DB.RunningInstances()
+			if len(running) == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				for _, instance := range running {
+					common.LoggerStd.Warn("drain timeout, interrupt", zap.String("taskName", instance.Context.TaskName))
+					server.stopScript(&TaskRequest{TaskName: instance.Context.TaskName})
+				}
+				waitInstances(running, drainInterruptGrace)
+				break
+			}
+			time.Sleep(time.Second)
+		}
+		goja.CloseAllWebClients()
+
+		if server.grpcServer != nil {
+			server.grpcServer.Stop()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := server.httpServer.Shutdown(ctx)
+		if err != nil {
+			common.LoggerStd.Error("merkaba shutdown", zap.Error(err))
+		}
+		if server.journal != nil {
+			server.journal.close()
+		}
+		common.LoggerStd.Info("merkaba drained")
+	})
+}
+
+// waitInstances 等待实例这次执行结束(Run退出或者取消),最多等待grace
+func waitInstances(instances []*goja.ScriptInstance, grace time.Duration) {
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	for _, instance := range instances {
+		select {
+		case <-instance.Done():
+		case <-timer.C:
+			common.LoggerStd.Warn("drain interrupt timeout", zap.Int("instances", len(instances)))
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+)
+
+// ErrorCode 接口返回的错误码,调用方按code判断错误类型,取值不能修改
+type ErrorCode string
+
+const (
+	ErrInvalidRequest     ErrorCode = "InvalidRequest"
+	ErrUnauthorized       ErrorCode = "Unauthorized"
+	ErrForbidden          ErrorCode = "Forbidden"
+	ErrInstanceNotFound   ErrorCode = "InstanceNotFound"
+	ErrInstanceRunning    ErrorCode = "InstanceRunning"
+	ErrInstanceNotRunning ErrorCode = "InstanceNotRunning"
+	ErrRunNotFound        ErrorCode = "RunNotFound"
+	ErrBatchNotFound      ErrorCode = "BatchNotFound"
+	ErrScheduleNotFound   ErrorCode = "ScheduleNotFound"
+	ErrScriptNotFound     ErrorCode = "ScriptNotFound"
+	ErrArtifactNotFound   ErrorCode = "ArtifactNotFound"
+	ErrPageNotFound       ErrorCode = "PageNotFound"
+	ErrCapacityExceeded   ErrorCode = "CapacityExceeded"
+	ErrQueueFull          ErrorCode = "QueueFull"
+	ErrDraining           ErrorCode = "Draining"
+	ErrInternal           ErrorCode = "InternalError"
+)
+
+var errorStatus = map[ErrorCode]int{
+	ErrInvalidRequest:     http.StatusBadRequest,
+	ErrUnauthorized:       http.StatusUnauthorized,
+	ErrForbidden:          http.StatusForbidden,
+	ErrInstanceNotFound:   http.StatusNotFound,
+	ErrInstanceRunning:    http.StatusConflict,
+	ErrInstanceNotRunning: http.StatusConflict,
+	ErrRunNotFound:        http.StatusNotFound,
+	ErrBatchNotFound:      http.StatusNotFound,
+	ErrScheduleNotFound:   http.StatusNotFound,
+	ErrScriptNotFound:     http.StatusNotFound,
+	ErrArtifactNotFound:   http.StatusNotFound,
+	ErrPageNotFound:       http.StatusNotFound,
+	ErrCapacityExceeded:   http.StatusServiceUnavailable,
+	ErrQueueFull:          http.StatusTooManyRequests,
+	ErrDraining:           http.StatusServiceUnavailable,
+	ErrInternal:           http.StatusInternalServerError,
+}
+
+// Status 错误码对应的http状态码
+func (code ErrorCode) Status() int {
+	if status, ok := errorStatus[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+/*处理请求时的错误,由handler统一转换成响应*/
+type apiError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func newApiError(code ErrorCode, message string) *apiError {
+	return &apiError{Code: code, Message: message}
+}
+
+func (e *apiError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"io"
+	"merkaba/common"
+	"time"
+)
+
+// registerEvents 以SSE的方式推送任务的日志,数据和状态变化,taskName为空时推送所有任务
+func (server *HttpServer) registerEvents() {
+	server.instance.GET("/events", server.authorize(ScopeRun), func(c *gin.Context) {
+		taskName := c.Query("taskName")
+		events, cancel := common.Events.Subscribe(taskName)
+		defer cancel()
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case event := <-events:
+				name, _ := event["type"].(string)
+				c.SSEvent(name, event)
+				return true
+			case <-ticker.C:
+				/*保持连接,防止代理断开空闲的连接*/
+				c.SSEvent("ping", time.Now().UnixMilli())
+				return true
+			}
+		})
+	})
+}
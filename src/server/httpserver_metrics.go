@@ -0,0 +1,19 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"merkaba/common"
+	"merkaba/common/metrics"
+)
+
+// registerMetrics 以prometheus的格式输出节点的运行指标,需要metrics权限,auth.publicMetrics为true时公开
+func (server *HttpServer) registerMetrics() {
+	metrics.WatchQueue(Queue)
+	handler := gin.WrapH(promhttp.Handler())
+	if common.Env.Auth.PublicMetrics {
+		server.instance.GET("/metrics", handler)
+		return
+	}
+	server.instance.GET("/metrics", server.authorize(ScopeMetrics), handler)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"merkaba/common"
+)
+
+// registerNodes 集群中登记的节点和最近一次心跳上报的负载
+func (server *HttpServer) registerNodes() {
+	server.instance.GET("/nodes", server.authorize(ScopeAdmin), func(c *gin.Context) {
+		json, err := server.readNodes()
+		if err != nil {
+			server.writeError(c, err)
+			return
+		}
+		server.writeResponse(c, json)
+	})
+}
+
+func (server *HttpServer) readNodes() (gin.H, *apiError) {
+	nodes, err := server.DB.Nodes.Nodes()
+	if err != nil {
+		return nil, newApiError(ErrInternal, err.Error())
+	}
+	items := make([]any, 0, len(nodes))
+	for _, node := range nodes {
+		items = append(items, node)
+	}
+	json := successResp()
+	json["nodes"] = items
+	json["heartbeat"] = common.NodeHeartbeat().Seconds()
+	json["expire"] = common.NodeExpire().Seconds()
+	return json, nil
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// registerPauseScript 暂停和恢复运行中的脚本,暂停期间可以通过VNC手工处理页面
+func (server *HttpServer) registerPauseScript() {
+	server.instance.POST("/pauseScript", server.authorize(ScopeRun), func(c *gin.Context) {
+		var req TaskRequest
+		if !server.bindJSON(c, &req) {
+			return
+		}
+		json, err := server.pauseScript(&req)
+		if err != nil {
+			server.writeError(c, err)
+			return
+		}
+		server.writeResponse(c, json)
+	})
+	server.instance.POST("/resumeScript", server.authorize(ScopeRun), func(c *gin.Context) {
+		var req TaskRequest
+		if !server.bindJSON(c, &req) {
+			return
+		}
+		json, err := server.resumeScript(&req)
+		if err != nil {
+			server.writeError(c, err)
+			return
+		}
+		server.writeResponse(c, json)
+	})
+}
+
+func (server *HttpServer) pauseScript(req *TaskRequest) (gin.H, *apiError) {
+	instance := server.DB.FindMemInstance(req.TaskName)
+	if instance == nil {
+		return nil, newApiError(ErrInstanceNotFound, "不存在")
+	}
+	if !instance.Pause() {
+		return nil, newApiError(ErrInstanceNotRunning, "实例没有运行或者已经暂停")
+	}
+	m := successResp()
+	m["taskName"] = req.TaskName
+	m["runId"] = instance.Context.RunId
+	return m, nil
+}
+
+func (server *HttpServer) resumeScript(req *TaskRequest) (gin.H, *apiError) {
+	instance := server.DB.FindMemInstance(req.TaskName)
+	if instance == nil {
+		return nil, newApiError(ErrInstanceNotFound, "不存在")
+	}
+	if !instance.Resume() {
+		return nil, newApiError(ErrInstanceNotRunning, "实例没有暂停")
+	}
+	m := successResp()
+	m["taskName"] = req.TaskName
+	m["runId"] = instance.Context.RunId
+	return m, nil
+}
@@ -2,34 +2,47 @@ package server
 
 import (
 	"github.com/gin-gonic/gin"
+	"merkaba/goja"
 )
 
 func (server *HttpServer) registerReadScriptCount() {
-	server.instance.POST("/readScriptCount", func(c *gin.Context) {
-		data := server.parseData(c)
-		includeDetail := false
-		if data != nil {
-			includeDetail = data["includeDetail"].(bool)
+	server.instance.POST("/readScriptCount", server.authorize(ScopeRun), func(c *gin.Context) {
+		var req ReadScriptCountRequest
+		if !server.bindOptionalJSON(c, &req) {
+			return
 		}
-		json := successResp()
-		runCount := 0
-		idleCount := 0
-		items := make([]any, 0)
-		for _, i := range server.DB.Instances {
-			if i.Status == "Running" {
-				runCount += 1
-			} else {
-				idleCount += 1
-			}
-			if includeDetail {
-				items = append(items, i.AsMap())
-			}
+		server.writeResponse(c, server.readScriptCount(&req))
+	})
+}
+
+func (server *HttpServer) readScriptCount(req *ReadScriptCountRequest) gin.H {
+	json := successResp()
+	runCount := 0
+	queuedCount := 0
+	idleCount := 0
+	items := make([]any, 0)
+	for _, i := range server.DB.Instances.All() {
+		status := i.Status()
+		if status == goja.InstanceRunning {
+			runCount += 1
+		} else if status == goja.InstanceQueued {
+			queuedCount += 1
+		} else {
+			idleCount += 1
 		}
-		json["runCount"] = runCount
-		json["idleCount"] = idleCount
-		if includeDetail {
-			json["items"] = items
+		if req.IncludeDetail {
+			item := i.AsMap()
+			if status == goja.InstanceQueued {
+				item["position"] = Queue.Position(i)
+			}
+			items = append(items, item)
 		}
-		server.writeResponse(c, json)
-	})
+	}
+	json["runCount"] = runCount
+	json["queuedCount"] = queuedCount
+	json["idleCount"] = idleCount
+	if req.IncludeDetail {
+		json["items"] = items
+	}
+	return json
 }
@@ -6,24 +6,20 @@ import (
 )
 
 func (server *HttpServer) registerReadScriptInstance() {
-	server.instance.POST("/readScriptInstance", func(c *gin.Context) {
-		data := server.parseData(c)
-		if data == nil {
-			server.writeResponse(c, errorResp("data format error"))
+	server.instance.POST("/readScriptInstance", server.authorize(ScopeRun), func(c *gin.Context) {
+		var req ReadScriptInstanceRequest
+		if !server.bindJSON(c, &req) {
 			return
 		}
-		scriptUri := server.CheckDataField(c, data, "scriptUri")
-		taskName := server.CheckDataField(c, data, "taskName")
-
-		if len(scriptUri) == 0 || len(taskName) == 0 {
-			server.writeResponse(c, errorResp("scriptUri and taskName must be required"))
-			return
-		}
-		state := server.DB.ExistMemInstance(scriptUri, taskName)
-		json := successResp()
-		json["hasVNC"] = common.HasVNC(taskName)
-		json["state"] = state
-		json["totalCount"] = server.DB.InstanceCount()
-		server.writeResponse(c, json)
+		server.writeResponse(c, server.readScriptInstance(&req))
 	})
 }
+
+func (server *HttpServer) readScriptInstance(req *ReadScriptInstanceRequest) gin.H {
+	state := server.DB.ExistMemInstance(req.ScriptUri, req.TaskName)
+	json := successResp()
+	json["hasVNC"] = common.HasVNC(req.TaskName)
+	json["state"] = state
+	json["totalCount"] = server.DB.InstanceCount()
+	return json
+}
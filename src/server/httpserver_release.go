@@ -0,0 +1,162 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"merkaba/common"
+	"merkaba/goja"
+	"time"
+)
+
+// registerRelease 脚本的版本、灰度和回滚
+func (server *HttpServer) registerRelease() {
+	server.instance.GET("/releases", server.authorize(ScopeAdmin), func(c *gin.Context) {
+		var req ReleaseRequest
+		if !server.bindQuery(c, &req) {
+			return
+		}
+		json, err := server.readReleases(&req)
+		if err != nil {
+			server.writeError(c, err)
+			return
+		}
+		server.writeResponse(c, json)
+	})
+	server.instance.POST("/canary", server.authorize(ScopeAdmin), func(c *gin.Context) {
+		var req CanaryRequest
+		if !server.bindJSON(c, &req) {
+			return
+		}
+		json, err := server.saveCanary(&req)
+		if err != nil {
+			server.writeError(c, err)
+			return
+		}
+		server.writeResponse(c, json)
+	})
+	server.instance.POST("/rollback", server.authorize(ScopeAdmin), func(c *gin.Context) {
+		var req RollbackRequest
+		if !server.bindJSON(c, &req) {
+			return
+		}
+		json, err := server.rollback(&req)
+		if err != nil {
+			server.writeError(c, err)
+			return
+		}
+		server.writeResponse(c, json)
+	})
+}
+
+func (server *HttpServer) releaseScriptId(req *ReleaseRequest) (string, *apiError) {
+	if len(req.ScriptId) > 0 {
+		return req.ScriptId, nil
+	}
+	if len(req.ScriptUri) == 0 {
+		return "", newApiError(ErrInvalidRequest, "scriptId or scriptUri is required")
+	}
+	scriptId := server.DB.ReadScriptId(req.ScriptUri)
+	if len(scriptId) == 0 {
+		return "", newApiError(ErrScriptNotFound, "script not exist: "+req.ScriptUri)
+	}
+	return scriptId, nil
+}
+
+func (server *HttpServer) readReleases(req *ReleaseRequest) (gin.H, *apiError) {
+	scriptId, err := server.releaseScriptId(req)
+	if err != nil {
+		return nil, err
+	}
+	json := successResp()
+	json["scriptId"] = scriptId
+	if store, ok := server.DB.Store.(common.ChannelScriptStore); ok {
+		_, json[common.ScriptChannelDev] = store.ReadScriptChannel(scriptId, common.ScriptChannelDev)
+		_, json[common.ScriptChannelPrd] = store.ReadScriptChannel(scriptId, common.ScriptChannelPrd)
+		if rule := server.DB.ReadCanary(scriptId); rule != nil {
+			json[common.ScriptChannelCanary] = rule.AsMap()
+		}
+		versions, e := server.DB.ReadScriptVersions(scriptId)
+		if e != nil {
+			return nil, newApiError(ErrInternal, e.Error())
+		}
+		items := make([]any, 0, len(versions))
+		for _, v := range versions {
+			items = append(items, v.AsMap())
+		}
+		json["versions"] = items
+		releases, e := server.DB.ReadScriptReleases(scriptId)
+		if e != nil {
+			return nil, newApiError(ErrInternal, e.Error())
+		}
+		history := make([]any, 0, len(releases))
+		for _, r := range releases {
+			history = append(history, r.AsMap())
+		}
+		json["releases"] = history
+	} else {
+		_, json["version"] = server.DB.ReadScript(scriptId)
+	}
+	return json, nil
+}
+
+// saveCanary percent为0时取消灰度
+func (server *HttpServer) saveCanary(req *CanaryRequest) (gin.H, *apiError) {
+	scriptId, err := server.releaseScriptId(&req.ReleaseRequest)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := server.DB.Store.(common.ChannelScriptStore); !ok {
+		return nil, newApiError(ErrInvalidRequest, goja.ErrChannelNotSupported.Error())
+	}
+	json := successResp()
+	json["scriptId"] = scriptId
+	if req.Percent == 0 {
+		if e := server.DB.DeleteCanary(scriptId); e != nil {
+			return nil, newApiError(ErrInternal, e.Error())
+		}
+		json["canary"] = nil
+		return json, nil
+	}
+	if len(req.Version) == 0 {
+		return nil, newApiError(ErrInvalidRequest, "version is required")
+	}
+	store, ok := server.DB.Store.(common.VersionedScriptStore)
+	if !ok {
+		return nil, newApiError(ErrInvalidRequest, goja.ErrVersionNotSupported.Error())
+	}
+	if content, _ := store.ReadScriptVersion(scriptId, req.Version); len(content) == 0 {
+		return nil, newApiError(ErrScriptNotFound, "version not exist: "+req.Version)
+	}
+	rule := &goja.CanaryRule{
+		ScriptId:    scriptId,
+		Version:     req.Version,
+		Percent:     req.Percent,
+		CreatedTime: time.Now().UnixMilli(),
+	}
+	if e := server.DB.SaveCanary(rule); e != nil {
+		return nil, newApiError(ErrInternal, e.Error())
+	}
+	json["canary"] = rule.AsMap()
+	return json, nil
+}
+
+// rollback 正式版本回滚,version为空时回滚到上一个版本
+func (server *HttpServer) rollback(req *RollbackRequest) (gin.H, *apiError) {
+	scriptId, err := server.releaseScriptId(&req.ReleaseRequest)
+	if err != nil {
+		return nil, err
+	}
+	from, to, e := server.DB.Rollback(scriptId, req.Version)
+	switch {
+	case e == goja.ErrVersionNotFound:
+		return nil, newApiError(ErrScriptNotFound, "no version to rollback")
+	case e == goja.ErrVersionNotSupported:
+		return nil, newApiError(ErrInvalidRequest, e.Error())
+	case e != nil:
+		return nil, newApiError(ErrInternal, e.Error())
+	}
+	json := successResp()
+	json["scriptId"] = scriptId
+	json["from"] = from
+	json["to"] = to
+	return json, nil
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"reflect"
+	"strings"
+)
+
+type RunScriptRequest struct {
+	ScriptId      string           `json:"scriptId" binding:"required"`
+	ScriptUri     string           `json:"scriptUri" binding:"required"`
+	TaskName      string           `json:"taskName" binding:"required"`
+	Parameters    map[string]any   `json:"parameters"`
+	BreakPoints   []map[string]any `json:"breakPoints"`
+	Variables     []string         `json:"variables"`
+	CookieId      string           `json:"cookieId"`
+	AppServerIP   string           `json:"appServerIP"`
+	AppServerPort string           `json:"appServerPort"`
+	MaxWaitTime   int64            `json:"maxWaitTime" binding:"min=0"`
+	MaxRunTime    int64            `json:"maxRunTime" binding:"min=0"`
+	RunMode       string           `json:"runMode" binding:"omitempty,oneof=BrowserRun AppServer Native"`
+	Retry         *RetryRequest    `json:"retry"`
+	Version       string           `json:"version"`
+	Channel       string           `json:"channel" binding:"omitempty,oneof=dev prd canary"`
+	/*批量和定时任务使用低优先级,不对外开放*/
+	Priority int `json:"-"`
+	/*重放日志时沿用原来的runId,不对外开放*/
+	RunId string `json:"-"`
+}
+
+// RetryRequest 失败后的重试策略,覆盖脚本配置的策略,时间单位是毫秒
+type RetryRequest struct {
+	MaxAttempts int      `json:"maxAttempts" binding:"min=1,max=10"`
+	Backoff     int64    `json:"backoff" binding:"min=0"`
+	MaxBackoff  int64    `json:"maxBackoff" binding:"min=0"`
+	Multiplier  float64  `json:"multiplier" binding:"min=0"`
+	RetryOn     []string `json:"retryOn" binding:"dive,oneof=timeout browser rpc script any"`
+}
+
+// ReleaseRequest 按scriptId或者scriptUri指定脚本
+type ReleaseRequest struct {
+	ScriptId  string `json:"scriptId" form:"scriptId"`
+	ScriptUri string `json:"scriptUri" form:"scriptUri"`
+}
+
+type CanaryRequest struct {
+	ReleaseRequest
+	Version string `json:"version"`
+	Percent int    `json:"percent" binding:"min=0,max=100"`
+}
+
+type RollbackRequest struct {
+	ReleaseRequest
+	Version string `json:"version"`
+}
+
+type WorkersRequest struct {
+	Count int `json:"count" binding:"min=1,max=1024"`
+}
+
+type RunInlineRequest struct {
+	Source        string            `json:"source" binding:"required"`
+	Modules       map[string]string `json:"modules"`
+	ScriptUri     string            `json:"scriptUri"`
+	TaskName      string            `json:"taskName"`
+	Parameters    map[string]any    `json:"parameters"`
+	BreakPoints   []map[string]any  `json:"breakPoints"`
+	Variables     []string          `json:"variables"`
+	CookieId      string            `json:"cookieId"`
+	AppServerIP   string            `json:"appServerIP"`
+	AppServerPort string            `json:"appServerPort"`
+	MaxWaitTime   int64             `json:"maxWaitTime" binding:"min=0"`
+	RunMode       string            `json:"runMode" binding:"omitempty,oneof=BrowserRun AppServer Native"`
+}
+
+type RunBatchRequest struct {
+	ScriptId      string           `json:"scriptId" binding:"required"`
+	ScriptUri     string           `json:"scriptUri" binding:"required"`
+	TaskPrefix    string           `json:"taskPrefix"`
+	Parameters    map[string]any   `json:"parameters"`
+	Items         []map[string]any `json:"items" binding:"required,min=1,max=1000"`
+	Concurrency   int              `json:"concurrency" binding:"min=0"`
+	CookieId      string           `json:"cookieId"`
+	AppServerIP   string           `json:"appServerIP"`
+	AppServerPort string           `json:"appServerPort"`
+	MaxWaitTime   int64            `json:"maxWaitTime" binding:"min=0"`
+	RunMode       string           `json:"runMode" binding:"omitempty,oneof=BrowserRun AppServer Native"`
+}
+
+type BatchRequest struct {
+	BatchId string `json:"batchId" binding:"required"`
+}
+
+type ScheduleRequest struct {
+	Name       string         `json:"name"`
+	Cron       string         `json:"cron" binding:"required"`
+	Timezone   string         `json:"timezone"`
+	ScriptId   string         `json:"scriptId"`
+	ScriptUri  string         `json:"scriptUri" binding:"required"`
+	TaskName   string         `json:"taskName"`
+	Parameters map[string]any `json:"parameters"`
+	Overlap    string         `json:"overlap" binding:"omitempty,oneof=skip queue replace"`
+	Enabled    *bool          `json:"enabled"`
+}
+
+type TaskRequest struct {
+	TaskName string `json:"taskName" form:"taskName" binding:"required"`
+}
+
+type DebugScriptRequest struct {
+	ScriptId string `json:"scriptId"`
+	TaskName string `json:"taskName" binding:"required"`
+	Command  string `json:"command" binding:"required"`
+	Line     *int   `json:"line"`
+}
+
+type WatchScriptRequest struct {
+	ScriptId  string   `json:"scriptId"`
+	TaskName  string   `json:"taskName" binding:"required"`
+	Variables []string `json:"variables" binding:"required"`
+}
+
+type ReadScriptCountRequest struct {
+	IncludeDetail bool `json:"includeDetail"`
+}
+
+type ReadScriptInstanceRequest struct {
+	ScriptUri string `json:"scriptUri" binding:"required"`
+	TaskName  string `json:"taskName" binding:"required"`
+}
+
+type RunsRequest struct {
+	ScriptUri string `form:"scriptUri"`
+	TaskName  string `form:"taskName"`
+	Status    string `form:"status"`
+	From      int64  `form:"from" binding:"min=0"`
+	To        int64  `form:"to" binding:"min=0"`
+	Limit     int    `form:"limit" binding:"min=0,max=1000"`
+	Offset    int    `form:"offset" binding:"min=0"`
+}
+
+type ArtifactsRequest struct {
+	RunId string `form:"runId" binding:"required"`
+}
+
+func init() {
+	/*校验错误里使用json的字段名,和请求里的一致*/
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			for _, tag := range []string{"json", "form"} {
+				name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+				if len(name) > 0 && name != "-" {
+					return name
+				}
+			}
+			return field.Name
+		})
+	}
+}
+
+// bindJSON 解析并校验请求体,失败时写入InvalidRequest响应并返回false
+func (server *HttpServer) bindJSON(c *gin.Context, req any) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		server.writeError(c, newApiError(ErrInvalidRequest, bindMessage(err)))
+		return false
+	}
+	return true
+}
+
+// bindOptionalJSON 请求体可以为空,为空时req保持默认值
+func (server *HttpServer) bindOptionalJSON(c *gin.Context, req any) bool {
+	err := c.ShouldBindJSON(req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		server.writeError(c, newApiError(ErrInvalidRequest, bindMessage(err)))
+		return false
+	}
+	return true
+}
+
+func (server *HttpServer) bindQuery(c *gin.Context, req any) bool {
+	if err := c.ShouldBindQuery(req); err != nil {
+		server.writeError(c, newApiError(ErrInvalidRequest, bindMessage(err)))
+		return false
+	}
+	return true
+}
+
+func bindMessage(err error) string {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return "data format error: " + err.Error()
+	}
+	messages := make([]string, 0, len(errs))
+	for _, e := range errs {
+		switch e.Tag() {
+		case "required":
+			messages = append(messages, e.Field()+" must be required")
+		case "oneof":
+			messages = append(messages, fmt.Sprintf("%s must be one of [%s]", e.Field(), e.Param()))
+		case "min":
+			messages = append(messages, fmt.Sprintf("%s must be >= %s", e.Field(), e.Param()))
+		case "max":
+			messages = append(messages, fmt.Sprintf("%s must be <= %s", e.Field(), e.Param()))
+		default:
+			messages = append(messages, fmt.Sprintf("%s must be %s %s", e.Field(), e.Tag(), e.Param()))
+		}
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"merkaba/common"
+	"merkaba/goja"
+)
+
+/*内联脚本没有保存在数据库,记录为这个版本*/
+const inlineScriptVersion = goja.InlineScriptVersion
+
+/*没有指定taskName时生成的实例名称前缀,这些实例只执行一次*/
+const inlineTaskPrefix = "inline_"
+
+// registerRunInline 直接执行请求中的脚本源码,用于开发调试;生产环境需要dev权限
+func (server *HttpServer) registerRunInline() {
+	scope := ScopeRun
+	if common.Env.Environment.Production {
+		scope = ScopeDev
+	}
+	server.instance.POST("/runInline", server.authorize(scope), func(c *gin.Context) {
+		if common.Env.Environment.Production && !server.authenticator.enabled {
+			server.writeError(c, newApiError(ErrForbidden, "runInline is disabled in production without auth"))
+			return
+		}
+		var req RunInlineRequest
+		if !server.bindJSON(c, &req) {
+			return
+		}
+		json, err := server.runInline(&req)
+		if err != nil {
+			server.writeError(c, err)
+			return
+		}
+		server.writeResponse(c, json)
+	})
+}
+
+func (server *HttpServer) runInline(req *RunInlineRequest) (gin.H, *apiError) {
+	if server.draining.Load() {
+		return nil, newApiError(ErrDraining, "merkaba is draining")
+	}
+	taskName := req.TaskName
+	if len(taskName) == 0 {
+		taskName = inlineTaskPrefix + primitive.NewObjectID().Hex()
+	}
+	scriptUri := req.ScriptUri
+	if len(scriptUri) == 0 {
+		scriptUri = "inline/" + taskName
+	}
+	instance, err := server.queueScript(&RunScriptRequest{
+		ScriptUri:     scriptUri,
+		TaskName:      taskName,
+		Parameters:    req.Parameters,
+		BreakPoints:   req.BreakPoints,
+		Variables:     req.Variables,
+		CookieId:      req.CookieId,
+		AppServerIP:   req.AppServerIP,
+		AppServerPort: req.AppServerPort,
+		MaxWaitTime:   req.MaxWaitTime,
+		RunMode:       req.RunMode,
+	}, inlineScriptVersion, req.Source, req.Modules)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.TaskName) == 0 {
+		server.disposeAfterRun(instance)
+	}
+	json := successResp()
+	json["scriptUri"] = scriptUri
+	json["scriptVersion"] = inlineScriptVersion
+	json["taskName"] = taskName
+	json["runId"] = instance.Context.RunId
+	json["ip"] = common.LocalIP
+	queueStatus(json, instance)
+	return json, nil
+}
+
+// disposeAfterRun 只执行一次的实例,执行结束或者取消后删除,同时关闭浏览器,不占用节点的容量
+func (server *HttpServer) disposeAfterRun(instance *goja.ScriptInstance) {
+	done := instance.Done()
+	go func() {
+		<-done
+		server.DB.EvictMemInstance(instance, goja.EvictOneShot)
+	}()
+}
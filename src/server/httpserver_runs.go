@@ -0,0 +1,54 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"merkaba/goja"
+)
+
+// registerRuns 查询脚本的执行记录
+func (server *HttpServer) registerRuns() {
+	server.instance.GET("/runs", server.authorize(ScopeRun), func(c *gin.Context) {
+		var req RunsRequest
+		if !server.bindQuery(c, &req) {
+			return
+		}
+		records, err := server.DB.QueryRuns(goja.RunFilter(req))
+		if err != nil {
+			server.writeError(c, newApiError(ErrInternal, err.Error()))
+			return
+		}
+		items := make([]any, 0, len(records))
+		for _, record := range records {
+			items = append(items, record.AsMap())
+		}
+		json := successResp()
+		json["items"] = items
+		server.writeResponse(c, json)
+	})
+	server.instance.GET("/runs/:id", server.authorize(ScopeRun), func(c *gin.Context) {
+		record, err := server.DB.ReadRun(c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			server.writeError(c, newApiError(ErrRunNotFound, "run not exist"))
+			return
+		}
+		if err != nil {
+			server.writeError(c, newApiError(ErrInternal, err.Error()))
+			return
+		}
+		attempts, err := server.DB.ReadAttempts(record.Id)
+		if err != nil {
+			server.writeError(c, newApiError(ErrInternal, err.Error()))
+			return
+		}
+		items := make([]any, 0, len(attempts))
+		for _, attempt := range attempts {
+			items = append(items, attempt.AsMap())
+		}
+		json := successResp()
+		json["run"] = record.AsMap()
+		json["attempts"] = items
+		server.writeResponse(c, json)
+	})
+}
@@ -1,103 +1,174 @@
 package server
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"merkaba/common"
+	"merkaba/common/queue"
+	"merkaba/goja"
 	"strings"
 )
 
-func (server *HttpServer) CheckDataField(c *gin.Context, data map[string]interface{}, fieldName string) string {
-	if v, ok := data[fieldName]; ok {
-		return v.(string)
-	} else {
-		server.writeResponse(c, errorResp("data miss "+fieldName))
-		return ""
-	}
-}
-
 func (server *HttpServer) registerRunScript() {
-	server.instance.POST("/runScript", func(c *gin.Context) {
-		data := server.parseData(c)
-		if data == nil {
-			server.writeResponse(c, errorResp("data format error"))
-			return
-		}
-		scriptId := server.CheckDataField(c, data, "scriptId")
-		if len(scriptId) == 0 {
-			return
-		}
-		scriptUri := server.CheckDataField(c, data, "scriptUri")
-		if len(scriptUri) == 0 {
+	server.instance.POST("/runScript", server.authorize(ScopeRun), func(c *gin.Context) {
+		var req RunScriptRequest
+		if !server.bindJSON(c, &req) {
 			return
 		}
-		taskName := server.CheckDataField(c, data, "taskName")
-		if len(taskName) == 0 {
+		json, err := server.runScript(&req)
+		if err != nil {
+			server.writeError(c, err)
 			return
 		}
-		var parameters map[string]interface{}
-		if v, ok := data["parameters"]; ok {
-			parameters = v.(map[string]interface{})
-		}
-		localNode := server.DB.ReadLocalMerkabaNode()
-		/*如果网站有验证的脚本，编译后使用*/
-		names := strings.Split(scriptUri, "/")
-		if len(names) == 0 {
-			server.writeResponse(c, errorResp("uri can't find siteName"))
-			return
-		}
-		siteName := names[0]
-		/*读取脚本内容和版本*/
-		var builder strings.Builder
-		name := siteName + "/timeout"
-		script, _ := server.DB.ReadScriptByUri(name)
-		if len(script) > 0 {
-			builder.WriteString(script)
-		}
-		var scriptVersion string
-		script, scriptVersion = server.DB.ReadScript(scriptId)
-		builder.WriteString(script)
-		scriptContent := builder.String()
-		instance := server.buildScriptInstance(c, localNode, siteName, scriptId, scriptUri, scriptVersion, scriptContent, parameters, taskName)
-		if instance == nil {
-			return
-		}
-		server.DB.UseMemInstance(instance)
-		if vv, ok := data["breakPoints"]; ok && len(vv.([]any)) > 0 {
-			instance.BreakPoints = make([]map[string]any, 0)
-			for _, v := range vv.([]any) {
-				instance.BreakPoints = append(instance.BreakPoints, v.(map[string]any))
-			}
-		}
-		if v, ok := data["variables"]; ok && len(v.([]any)) > 0 {
-			instance.Variables = common.CopyArray[string](v.([]string))
-		}
-		if v, ok := data["cookieId"]; ok {
-			instance.Context.CookieId = v.(string)
-		}
-		if v, ok := data["appServerIP"]; ok {
-			instance.Context.AppServerIP = v.(string)
-		}
-		if v, ok := data["appServerPort"]; ok {
-			instance.Context.AppServerPort = v.(string)
-		}
-		if v, ok := data["maxWaitTime"]; ok {
-			instance.Context.MaxWaitTime = common.ParseInt64(v)
-		}
-		if instance.Context.MaxWaitTime == 0 {
-			instance.Context.MaxWaitTime = 10
-		}
-		instance.Context.RunMode = common.RunModeAppServer
-		if v, ok := data["runMode"]; ok {
-			instance.Context.RunMode = common.ParseRunMode(v.(string))
-		}
-
-		Queue.Enqueue(instance)
-		json := successResp()
-		json["scriptId"] = scriptId
-		json["scriptUri"] = scriptUri
-		json["scriptVersion"] = scriptVersion
-		json["taskName"] = taskName
-		json["ip"] = common.LocalIP
 		server.writeResponse(c, json)
 	})
 }
+
+func (server *HttpServer) runScript(req *RunScriptRequest) (gin.H, *apiError) {
+	instance, err := server.submitScript(req)
+	if err != nil {
+		return nil, err
+	}
+	json := successResp()
+	json["scriptId"] = req.ScriptId
+	json["scriptUri"] = req.ScriptUri
+	json["scriptVersion"] = instance.Context.ScriptVersion
+	json["channel"] = instance.Context.ScriptChannel
+	json["taskName"] = req.TaskName
+	json["runId"] = instance.Context.RunId
+	json["ip"] = common.LocalIP
+	queueStatus(json, instance)
+	return json, nil
+}
+
+// queueStatus 提交后立即返回,等待执行时附带在队列中的位置
+func queueStatus(json gin.H, instance *goja.ScriptInstance) {
+	if position := Queue.Position(instance); position > 0 {
+		json["status"] = "queued"
+		json["position"] = position
+	} else {
+		json["status"] = "running"
+	}
+}
+
+// submitScript 创建或者复用实例,提交到执行队列
+func (server *HttpServer) submitScript(req *RunScriptRequest) (*goja.ScriptInstance, *apiError) {
+	if server.draining.Load() {
+		return nil, newApiError(ErrDraining, "merkaba is draining")
+	}
+	/*按指定的版本或者渠道读取脚本,没有指定时按灰度规则选择*/
+	script, scriptVersion, channel, e := server.DB.ResolveScript(req.ScriptId, req.Version, req.Channel)
+	if e == goja.ErrVersionNotFound {
+		return nil, newApiError(ErrScriptNotFound, fmt.Sprintf("script %s version %s not exist", req.ScriptId, req.Version))
+	} else if e == goja.ErrScriptNotFound {
+		return nil, newApiError(ErrScriptNotFound, fmt.Sprintf("script %s not exist", req.ScriptId))
+	} else if e != nil {
+		return nil, newApiError(ErrInvalidRequest, e.Error())
+	}
+	/*记录实际使用的渠道,重放时使用同一个渠道*/
+	req.Channel = channel
+	return server.queueScript(req, scriptVersion, script, nil)
+}
+
+// queueLimits 执行队列的并发限制,merkaba_node中配置的值优先
+func queueLimits(node *goja.MerkabaNode) queue.Limits {
+	limits := queue.Limits{
+		Site:    common.Env.Queue.SiteLimit,
+		Account: common.Env.Queue.AccountLimit,
+		Sites:   common.Env.Queue.Sites,
+	}
+	if node.SiteLimit > 0 {
+		limits.Site = node.SiteLimit
+	}
+	if node.AccountLimit > 0 {
+		limits.Account = node.AccountLimit
+	}
+	return limits
+}
+
+// queueScript 按脚本内容创建实例并提交到执行队列,modules是require时优先使用的模块源码
+func (server *HttpServer) queueScript(req *RunScriptRequest, scriptVersion string, script string,
+	modules map[string]string) (*goja.ScriptInstance, *apiError) {
+	/*如果网站有验证的脚本，编译后使用*/
+	names := strings.Split(req.ScriptUri, "/")
+	if len(names[0]) == 0 {
+		return nil, newApiError(ErrInvalidRequest, "uri can't find siteName")
+	}
+	siteName := names[0]
+	var builder strings.Builder
+	name := siteName + "/timeout"
+	timeout, _ := server.DB.ReadScriptByUri(name)
+	if len(timeout) > 0 {
+		builder.WriteString(timeout)
+	}
+	builder.WriteString(script)
+	scriptContent := builder.String()
+	instance, err := server.buildScriptInstance(siteName, req.ScriptId, req.ScriptUri, scriptVersion, scriptContent, req.Parameters, req.TaskName)
+	if err != nil {
+		return nil, err
+	}
+	instance.Modules = modules
+	instance.Context.ScriptChannel = req.Channel
+	if len(req.BreakPoints) > 0 {
+		instance.BreakPoints = req.BreakPoints
+	}
+	if len(req.Variables) > 0 {
+		instance.Variables = common.CopyArray[string](req.Variables)
+	}
+	if len(req.CookieId) > 0 {
+		instance.Context.CookieId = req.CookieId
+	}
+	if len(req.AppServerIP) > 0 {
+		instance.Context.AppServerIP = req.AppServerIP
+	}
+	if len(req.AppServerPort) > 0 {
+		instance.Context.AppServerPort = req.AppServerPort
+	}
+	if req.MaxWaitTime > 0 {
+		instance.Context.MaxWaitTime = req.MaxWaitTime
+	}
+	if instance.Context.MaxWaitTime == 0 {
+		instance.Context.MaxWaitTime = 10
+	}
+	instance.Context.RunMode = common.RunModeAppServer
+	if len(req.RunMode) > 0 {
+		instance.Context.RunMode = common.ParseRunMode(req.RunMode)
+	}
+	/*请求中的重试策略优先,其次是脚本配置的策略*/
+	instance.Retry = nil
+	if req.Retry != nil {
+		policy := goja.RetryPolicy(*req.Retry)
+		instance.Retry = &policy
+	} else if len(req.ScriptId) > 0 {
+		instance.Retry = server.DB.ReadRetryPolicy(req.ScriptId)
+	}
+	/*运行时间限制(秒),请求中的优先,其次是脚本的配置*/
+	instance.MaxRunTime = req.MaxRunTime
+	if instance.MaxRunTime == 0 && len(req.ScriptId) > 0 {
+		instance.MaxRunTime = server.DB.ReadMaxRunTime(req.ScriptId)
+	}
+	/*浏览器里调试的任务优先执行*/
+	instance.Priority = req.Priority
+	if instance.Priority == 0 && instance.Context.RunMode == common.RunModeBrowserRun {
+		instance.Priority = queue.PriorityHigh
+	}
+
+	instance.Queued(req.RunId)
+	/*重放的任务已经在日志中,提交失败时保留*/
+	journal := server.journal
+	if len(req.RunId) > 0 {
+		journal = nil
+	}
+	if journal != nil {
+		journal.queued(instance, req, scriptVersion, script, modules)
+	}
+	if _, e := Queue.Offer(instance); e != nil {
+		if journal != nil {
+			journal.remove(instance.Context.RunId)
+		}
+		common.PublishState(instance.Context, common.StateStopped, e.Error())
+		instance.Discard()
+		return nil, newApiError(ErrQueueFull, fmt.Sprintf("%s, %d tasks pending", e.Error(), Queue.Pending()))
+	}
+	return instance, nil
+}
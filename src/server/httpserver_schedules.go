@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"merkaba/common"
+	"merkaba/goja"
+	"time"
+)
+
+// registerSchedules 定时任务的增删改查,定时任务以节点的身份执行,修改需要admin权限
+func (server *HttpServer) registerSchedules() {
+	server.instance.GET("/schedules", server.authorize(ScopeRun), func(c *gin.Context) {
+		records, err := server.DB.ReadSchedules()
+		if err != nil {
+			server.writeError(c, newApiError(ErrInternal, err.Error()))
+			return
+		}
+		items := make([]any, 0, len(records))
+		for i := range records {
+			items = append(items, server.scheduleMap(&records[i]))
+		}
+		json := successResp()
+		json["items"] = items
+		server.writeResponse(c, json)
+	})
+	server.instance.GET("/schedules/:id", server.authorize(ScopeRun), func(c *gin.Context) {
+		record, err := server.DB.ReadSchedule(c.Param("id"))
+		if err != nil {
+			server.writeError(c, newApiError(ErrScheduleNotFound, "schedule not exist"))
+			return
+		}
+		json := successResp()
+		json["schedule"] = server.scheduleMap(record)
+		server.writeResponse(c, json)
+	})
+	server.instance.POST("/schedules", server.authorize(ScopeAdmin), func(c *gin.Context) {
+		var req ScheduleRequest
+		if !server.bindJSON(c, &req) {
+			return
+		}
+		now := time.Now().UnixMilli()
+		record := &goja.ScheduleRecord{
+			Id:          primitive.NewObjectID().Hex(),
+			IP:          common.LocalIP,
+			CreatedTime: now,
+		}
+		server.saveSchedule(c, record, &req)
+	})
+	server.instance.PUT("/schedules/:id", server.authorize(ScopeAdmin), func(c *gin.Context) {
+		var req ScheduleRequest
+		if !server.bindJSON(c, &req) {
+			return
+		}
+		record, err := server.DB.ReadSchedule(c.Param("id"))
+		if err != nil {
+			server.writeError(c, newApiError(ErrScheduleNotFound, "schedule not exist"))
+			return
+		}
+		server.saveSchedule(c, record, &req)
+	})
+	server.instance.DELETE("/schedules/:id", server.authorize(ScopeAdmin), func(c *gin.Context) {
+		id := c.Param("id")
+		if _, err := server.DB.ReadSchedule(id); err != nil {
+			server.writeError(c, newApiError(ErrScheduleNotFound, "schedule not exist"))
+			return
+		}
+		server.scheduler.remove(id)
+		if err := server.DB.DeleteSchedule(id); err != nil {
+			server.writeError(c, newApiError(ErrInternal, err.Error()))
+			return
+		}
+		json := successResp()
+		json["id"] = id
+		server.writeResponse(c, json)
+	})
+}
+
+func (server *HttpServer) saveSchedule(c *gin.Context, record *goja.ScheduleRecord, req *ScheduleRequest) {
+	if _, err := parseSchedule(req.Cron, req.Timezone); err != nil {
+		server.writeError(c, newApiError(ErrInvalidRequest, "invalid cron: "+err.Error()))
+		return
+	}
+	scriptId := req.ScriptId
+	if len(scriptId) == 0 {
+		scriptId = server.DB.ReadScriptId(req.ScriptUri)
+		if len(scriptId) == 0 {
+			server.writeError(c, newApiError(ErrScriptNotFound, "script not exist: "+req.ScriptUri))
+			return
+		}
+	}
+	parameters, _ := json.Marshal(req.Parameters)
+	record.Name = req.Name
+	record.Cron = req.Cron
+	record.Timezone = req.Timezone
+	record.ScriptId = scriptId
+	record.ScriptUri = req.ScriptUri
+	record.TaskName = req.TaskName
+	if len(record.TaskName) == 0 {
+		record.TaskName = "schedule_" + record.Id
+	}
+	record.Parameters = string(parameters)
+	record.Overlap = req.Overlap
+	if len(record.Overlap) == 0 {
+		record.Overlap = goja.OverlapSkip
+	}
+	record.Enabled = req.Enabled == nil || *req.Enabled
+	record.UpdatedTime = time.Now().UnixMilli()
+	if err := server.DB.SaveSchedule(record); err != nil {
+		server.writeError(c, newApiError(ErrInternal, err.Error()))
+		return
+	}
+	if err := server.scheduler.put(record); err != nil {
+		server.writeError(c, newApiError(ErrInternal, err.Error()))
+		return
+	}
+	json := successResp()
+	json["schedule"] = server.scheduleMap(record)
+	server.writeResponse(c, json)
+}
+
+func (server *HttpServer) scheduleMap(record *goja.ScheduleRecord) map[string]any {
+	data := record.AsMap()
+	data["nextTime"] = server.scheduler.nextTime(record.Id)
+	return data
+}
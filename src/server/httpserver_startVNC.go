@@ -6,22 +6,28 @@ import (
 )
 
 func (server *HttpServer) registerStartVNC() {
-	server.instance.POST("/startVNC", func(c *gin.Context) {
-		data := server.parseData(c)
-		if data == nil {
-			server.writeResponse(c, errorResp("data format error"))
+	server.instance.POST("/startVNC", server.authorize(ScopeVNC), func(c *gin.Context) {
+		var req TaskRequest
+		if !server.bindJSON(c, &req) {
 			return
 		}
-		taskName := server.CheckDataField(c, data, "taskName")
-		if len(taskName) == 0 {
-			server.writeResponse(c, errorResp("taskName must be required"))
+		json, err := server.startVNC(&req)
+		if err != nil {
+			server.writeError(c, err)
 			return
 		}
-		vi := common.StartVNC(common.VncWidth, common.VncHeight, taskName)
-		json := successResp()
-		json["port"] = vi.Port
-		json["width"] = common.VncWidth
-		json["height"] = common.VncHeight
 		server.writeResponse(c, json)
 	})
 }
+
+func (server *HttpServer) startVNC(req *TaskRequest) (gin.H, *apiError) {
+	vi := common.StartVNC(common.VncWidth, common.VncHeight, req.TaskName)
+	if vi == nil {
+		return nil, newApiError(ErrCapacityExceeded, "no free vnc port")
+	}
+	json := successResp()
+	json["port"] = vi.Port
+	json["width"] = common.VncWidth
+	json["height"] = common.VncHeight
+	return json, nil
+}
@@ -6,19 +6,16 @@ import (
 )
 
 func (server *HttpServer) registerStopVNC() {
-	server.instance.POST("/stopVNC", func(c *gin.Context) {
-		data := server.parseData(c)
-		if data == nil {
-			server.writeResponse(c, errorResp("data format error"))
+	server.instance.POST("/stopVNC", server.authorize(ScopeVNC), func(c *gin.Context) {
+		var req TaskRequest
+		if !server.bindJSON(c, &req) {
 			return
 		}
-		taskName := server.CheckDataField(c, data, "taskName")
-		if len(taskName) == 0 {
-			server.writeResponse(c, errorResp("taskName must be required"))
-			return
-		}
-		common.StopVNC(taskName, "WebClose")
-		json := successResp()
-		server.writeResponse(c, json)
+		server.writeResponse(c, server.stopVNC(&req))
 	})
 }
+
+func (server *HttpServer) stopVNC(req *TaskRequest) gin.H {
+	common.StopVNC(req.TaskName, "WebClose")
+	return successResp()
+}
@@ -3,23 +3,33 @@ package server
 import (
 	"github.com/gin-gonic/gin"
 	"merkaba/common"
+	"merkaba/goja"
 )
 
 func (server *HttpServer) registerStopScript() {
-	server.instance.POST("/stopScript", func(c *gin.Context) {
-		data := server.parseData(c)
-		if data == nil {
+	server.instance.POST("/stopScript", server.authorize(ScopeRun), func(c *gin.Context) {
+		var req TaskRequest
+		if !server.bindJSON(c, &req) {
 			return
 		}
-		taskName := data["taskName"].(string)
-		instance := server.DB.FindMemInstance(taskName)
-		isSuccess := instance != nil
-		if isSuccess {
-			instance.Interrupt()
-		}
-		common.StopVNC(taskName, "ScriptStop")
-		m := successResp()
-		m["taskName"] = taskName
-		server.writeResponse(c, m)
+		server.writeResponse(c, server.stopScript(&req))
 	})
 }
+
+func (server *HttpServer) stopScript(req *TaskRequest) gin.H {
+	m := successResp()
+	m["taskName"] = req.TaskName
+	instance := server.DB.FindMemInstance(req.TaskName)
+	/*还没有开始执行的直接从队列中移除*/
+	if instance != nil && instance.Status() == goja.InstanceQueued && Queue.Remove(instance) {
+		instance.Cancel("用户取消")
+		m["status"] = "cancelled"
+		return m
+	}
+	if instance != nil {
+		instance.Interrupt()
+	}
+	common.StopVNC(req.TaskName, "ScriptStop")
+	m["status"] = "stopped"
+	return m
+}
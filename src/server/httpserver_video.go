@@ -1,20 +1,90 @@
 package server
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"hash"
+	"hash/fnv"
+	"io"
 	"net/http"
 )
 
+const videoBoundary = "endofsection"
+
+/*根据hash丢弃和上一帧相同的画面*/
 type deduper struct {
 	h  hash.Hash32
 	h1 uint32
 	h2 uint32
 }
 
+func newDeduper() *deduper {
+	return &deduper{h: fnv.New32a()}
+}
+
+func (d *deduper) isDuplicate(frame []byte) bool {
+	d.h.Reset()
+	d.h.Write(frame)
+	d.h2 = d.h.Sum32()
+	if d.h2 == d.h1 {
+		return true
+	}
+	d.h1 = d.h2
+	return false
+}
+
+// registerVideo 以MJPEG的方式推送任务当前页面的画面,可以直接用<img>标签观看
 func (server *HttpServer) registerVideo() {
-	server.instance.POST("/video", func(c *gin.Context) {
-		c.Header("Content-Type", "multipart/x-mixed-replace;boundary=endofsection")
+	server.instance.GET("/video", server.authorize(ScopeVNC), func(c *gin.Context) {
+		var req TaskRequest
+		if !server.bindQuery(c, &req) {
+			return
+		}
+		instance := server.DB.FindMemInstance(req.TaskName)
+		if instance == nil {
+			server.writeError(c, newApiError(ErrInstanceNotFound, "不存在"))
+			return
+		}
+		client := instance.RunVM.Runtime.WebClient
+		if client == nil {
+			server.writeError(c, newApiError(ErrPageNotFound, "webClient not started"))
+			return
+		}
+		page := client.CurrentPage()
+		if page == nil {
+			server.writeError(c, newApiError(ErrPageNotFound, "page not exist"))
+			return
+		}
+		frames, stop, err := page.Screencast()
+		if err != nil {
+			server.writeError(c, newApiError(ErrInternal, err.Error()))
+			return
+		}
+		defer stop()
+		c.Header("Content-Type", "multipart/x-mixed-replace;boundary="+videoBoundary)
+		c.Header("Cache-Control", "no-cache")
 		c.Status(http.StatusOK)
+		d := newDeduper()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-page.Ctx.Done():
+				return false
+			case frame := <-frames:
+				if d.isDuplicate(frame) {
+					return true
+				}
+				_, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", videoBoundary, len(frame))
+				if err != nil {
+					return false
+				}
+				if _, err = w.Write(frame); err != nil {
+					return false
+				}
+				_, err = io.WriteString(w, "\r\n")
+				return err == nil
+			}
+		})
 	})
 }
@@ -5,27 +5,36 @@ import (
 )
 
 func (server *HttpServer) registerWatch() {
-	server.instance.POST("/watchScript", func(c *gin.Context) {
-		data := server.parseData(c)
-		if data == nil {
+	server.instance.POST("/watchScript", server.authorize(ScopeDebug), func(c *gin.Context) {
+		var req WatchScriptRequest
+		if !server.bindJSON(c, &req) {
 			return
 		}
-		scriptId := data["scriptId"].(string)
-		taskName := data["taskName"].(string)
-		instance := server.DB.FindMemInstance(taskName)
-		if instance == nil {
-			server.writeResponse(c, errorResp("不存在"))
+		json, err := server.watchScript(&req)
+		if err != nil {
+			server.writeError(c, err)
 			return
 		}
-		variables := data["variables"].([]string)
-		instance.Variables = variables
-		values := make(map[string]any)
-		for _, name := range variables {
-			values[name] = instance.RunVM.Runtime.Get(name).String()
-		}
-		m := successResp()
-		m["scriptId"] = scriptId
-		m["values"] = values
-		server.writeResponse(c, m)
+		server.writeResponse(c, json)
 	})
 }
+
+func (server *HttpServer) watchScript(req *WatchScriptRequest) (gin.H, *apiError) {
+	instance := server.DB.FindMemInstance(req.TaskName)
+	if instance == nil {
+		return nil, newApiError(ErrInstanceNotFound, "不存在")
+	}
+	instance.Variables = req.Variables
+	values := make(map[string]any)
+	for _, name := range req.Variables {
+		if v := instance.RunVM.Runtime.Get(name); v != nil {
+			values[name] = v.String()
+		} else {
+			values[name] = nil
+		}
+	}
+	m := successResp()
+	m["scriptId"] = req.ScriptId
+	m["values"] = values
+	return m, nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"merkaba/goja"
+	"time"
+)
+
+const (
+	defaultQueueWorkers = 8
+	defaultQueueRefresh = 60
+)
+
+// QueueWorkers 执行队列的worker数量:merkaba_node.maxCount优先,其次是server.yaml的配置
+func QueueWorkers(node *goja.MerkabaNode) int {
+	if node != nil && node.MaxCount > 0 {
+		return node.MaxCount
+	}
+	if common.Env.Queue.Workers > 0 {
+		return common.Env.Queue.Workers
+	}
+	return defaultQueueWorkers
+}
+
+func (server *HttpServer) registerWorkers() {
+	server.instance.GET("/workers", server.authorize(ScopeAdmin), func(c *gin.Context) {
+		server.writeResponse(c, server.workers())
+	})
+	server.instance.POST("/workers", server.authorize(ScopeAdmin), func(c *gin.Context) {
+		var req WorkersRequest
+		if !server.bindJSON(c, &req) {
+			return
+		}
+		json, err := server.resizeWorkers(&req)
+		if err != nil {
+			server.writeError(c, err)
+			return
+		}
+		server.writeResponse(c, json)
+	})
+}
+
+func (server *HttpServer) workers() gin.H {
+	json := successResp()
+	json["maxCount"] = server.localNode().MaxCount
+	json["workers"] = Queue.Size()
+	json["busy"] = Queue.Busy()
+	json["pending"] = Queue.Pending()
+	return json
+}
+
+// resizeWorkers 修改merkaba_node.maxCount并立即调整worker数量,缩小时运行中的任务不受影响
+func (server *HttpServer) resizeWorkers(req *WorkersRequest) (gin.H, *apiError) {
+	if err := server.DB.UpdateMaxCount(req.Count); err != nil {
+		return nil, newApiError(ErrInternal, err.Error())
+	}
+	server.refreshNode()
+	common.LoggerStd.Info("resize workers", zap.Int("count", req.Count))
+	return server.workers(), nil
+}
+
+// localNode 最近一次读取的merkaba_node,避免每个请求都查询数据库
+func (server *HttpServer) localNode() *goja.MerkabaNode {
+	if node := server.node.Load(); node != nil {
+		return node
+	}
+	return &goja.MerkabaNode{}
+}
+
+// refreshNode 重新读取merkaba_node,按maxCount调整worker数量,更新并发限制
+func (server *HttpServer) refreshNode() {
+	node, err := server.DB.ReadLocalMerkabaNode()
+	if err != nil {
+		/*读取失败时保留上一次的配置,还没有读取过时使用server.yaml的配置*/
+		if server.node.Load() != nil {
+			return
+		}
+		node = &goja.MerkabaNode{}
+	} else {
+		server.node.Store(node)
+	}
+	if Queue == nil {
+		return
+	}
+	if workers := QueueWorkers(node); workers != Queue.Size() {
+		common.LoggerStd.Info("queue workers", zap.Int("from", Queue.Size()), zap.Int("to", workers))
+		Queue.Resize(workers)
+	}
+	Queue.SetLimits(queueLimits(node))
+}
+
+// refreshCapacity 定期读取merkaba_node,直到节点下线
+func (server *HttpServer) refreshCapacity() {
+	refresh := common.Env.Queue.Refresh
+	if refresh <= 0 {
+		refresh = defaultQueueRefresh
+	}
+	ticker := time.NewTicker(time.Duration(refresh) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			server.refreshNode()
+		case <-server.drained:
+			return
+		}
+	}
+}
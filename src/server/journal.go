@@ -0,0 +1,221 @@
+package server
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"merkaba/goja"
+	"strings"
+	"time"
+)
+
+const (
+	journalQueued  = "queued"
+	journalRunning = "running"
+)
+
+var journalBucket = []byte("runs")
+
+/*节点重启时,运行中的任务按失败上报*/
+const journalInterruptedMessage = "merkaba restarted, run interrupted"
+
+/*重新提交时这些错误不会因为重试而改变,任务按失败上报;其他错误保留在日志中,下次启动时再重放*/
+var journalDropCodes = map[ErrorCode]bool{
+	ErrInvalidRequest: true,
+	ErrScriptNotFound: true,
+}
+
+// journalEntry 等待或者正在执行的任务,按runId保存
+type journalEntry struct {
+	RunId         string            `json:"runId"`
+	State         string            `json:"state"`
+	Request       RunScriptRequest  `json:"request"`
+	Priority      int               `json:"priority"`
+	SiteName      string            `json:"siteName"`
+	ScriptVersion string            `json:"scriptVersion"`
+	Source        string            `json:"source,omitempty"`
+	Modules       map[string]string `json:"modules,omitempty"`
+	QueuedTime    int64             `json:"queuedTime"`
+	StartTime     int64             `json:"startTime"`
+}
+
+// journal 执行队列的本地日志(bbolt),进程崩溃或者重新部署后重放
+type journal struct {
+	db *bolt.DB
+}
+
+func journalPath() string {
+	if len(common.Env.Server.Journal) > 0 {
+		return common.Env.Server.Journal
+	}
+	return common.Env.Path.Temp + "merkaba_journal.db"
+}
+
+func openJournal(path string) (*journal, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(journalBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &journal{db: db}, nil
+}
+
+func (j *journal) put(entry *journalEntry) {
+	data, _ := json.Marshal(entry)
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).Put([]byte(entry.RunId), data)
+	})
+	if err != nil {
+		common.LoggerStd.Error("journal put", zap.String("runId", entry.RunId), zap.Error(err))
+	}
+}
+
+func (j *journal) get(runId string) *journalEntry {
+	var entry *journalEntry
+	j.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(journalBucket).Get([]byte(runId)); data != nil {
+			entry = &journalEntry{}
+			if err := json.Unmarshal(data, entry); err != nil {
+				entry = nil
+			}
+		}
+		return nil
+	})
+	return entry
+}
+
+func (j *journal) remove(runId string) {
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).Delete([]byte(runId))
+	})
+	if err != nil {
+		common.LoggerStd.Error("journal remove", zap.String("runId", runId), zap.Error(err))
+	}
+}
+
+func (j *journal) entries() []*journalEntry {
+	result := make([]*journalEntry, 0)
+	j.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).ForEach(func(k, v []byte) error {
+			entry := &journalEntry{}
+			if err := json.Unmarshal(v, entry); err != nil {
+				common.LoggerStd.Error("journal entry", zap.String("runId", string(k)), zap.Error(err))
+				return nil
+			}
+			result = append(result, entry)
+			return nil
+		})
+	})
+	return result
+}
+
+// queued 提交到执行队列之前记录,内联脚本同时保存源码
+func (j *journal) queued(instance *goja.ScriptInstance, req *RunScriptRequest, scriptVersion string,
+	script string, modules map[string]string) {
+	entry := &journalEntry{
+		RunId:         instance.Context.RunId,
+		State:         journalQueued,
+		Request:       *req,
+		Priority:      req.Priority,
+		SiteName:      instance.Context.SiteName,
+		ScriptVersion: scriptVersion,
+		QueuedTime:    instance.QueuedTime,
+	}
+	if scriptVersion == inlineScriptVersion {
+		entry.Source = script
+		entry.Modules = modules
+	}
+	j.put(entry)
+}
+
+func (j *journal) Started(s *goja.ScriptInstance) {
+	entry := j.get(s.Context.RunId)
+	if entry == nil {
+		return
+	}
+	entry.State = journalRunning
+	entry.StartTime = s.StartTime
+	j.put(entry)
+}
+
+func (j *journal) Finished(runId string) {
+	j.remove(runId)
+}
+
+func (j *journal) close() {
+	if err := j.db.Close(); err != nil {
+		common.LoggerStd.Error("journal close", zap.Error(err))
+	}
+}
+
+// replayJournal 启动时重放日志:等待中的任务按原来的runId重新提交,运行中的任务按失败上报
+func (server *HttpServer) replayJournal() {
+	if server.journal == nil {
+		return
+	}
+	for _, entry := range server.journal.entries() {
+		req := entry.Request
+		req.Priority = entry.Priority
+		req.RunId = entry.RunId
+		fields := []zap.Field{zap.String("runId", entry.RunId), zap.String("taskName", req.TaskName), zap.String("state", entry.State)}
+		if entry.State == journalRunning {
+			common.LoggerStd.Warn("journal interrupted run", fields...)
+			server.journal.remove(entry.RunId)
+			server.reportInterrupted(entry, journalInterruptedMessage)
+			continue
+		}
+		/*提交成功后由执行结束时删除*/
+		var instance *goja.ScriptInstance
+		var err *apiError
+		if len(entry.Source) > 0 {
+			instance, err = server.queueScript(&req, inlineScriptVersion, entry.Source, entry.Modules)
+		} else {
+			instance, err = server.submitScript(&req)
+		}
+		if err == nil {
+			if len(entry.Source) > 0 && strings.HasPrefix(req.TaskName, inlineTaskPrefix) {
+				server.disposeAfterRun(instance)
+			}
+			common.LoggerStd.Info("journal requeue", fields...)
+			continue
+		}
+		if journalDropCodes[err.Code] {
+			common.LoggerStd.Error("journal drop: "+err.Message, fields...)
+			server.journal.remove(entry.RunId)
+			server.reportInterrupted(entry, err.Message)
+			continue
+		}
+		common.LoggerStd.Error("journal requeue: "+err.Message, fields...)
+	}
+}
+
+func (server *HttpServer) reportInterrupted(entry *journalEntry, message string) {
+	req := &entry.Request
+	instance := &goja.ScriptInstance{
+		Context: &common.RunContext{
+			RunMode:       common.ParseRunMode(req.RunMode),
+			AppServerIP:   req.AppServerIP,
+			AppServerPort: req.AppServerPort,
+			CookieId:      req.CookieId,
+			SiteName:      entry.SiteName,
+			TaskName:      req.TaskName,
+			RunId:         entry.RunId,
+			ScriptId:      req.ScriptId,
+			ScriptUri:     req.ScriptUri,
+			ScriptVersion: entry.ScriptVersion,
+			Parameters:    req.Parameters,
+		},
+		DB:         &server.DB,
+		QueuedTime: entry.QueuedTime,
+		StartTime:  entry.StartTime,
+	}
+	instance.ReportInterrupted(message)
+}
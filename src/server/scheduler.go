@@ -0,0 +1,161 @@
+package server
+
+import (
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"merkaba/common"
+	"merkaba/common/queue"
+	"merkaba/goja"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type scheduleEntry struct {
+	record  goja.ScheduleRecord
+	entryId cron.EntryID
+	/*执行期间持有,用来判断上一次触发是否结束*/
+	running sync.Mutex
+	/*overlap=queue时最多保留一次等待的触发*/
+	waiting atomic.Bool
+}
+
+// scheduler 按cron表达式触发脚本,定时任务保存在merkaba_schedule表,重启后重新加载
+type scheduler struct {
+	server  *HttpServer
+	cron    *cron.Cron
+	lock    sync.Mutex
+	entries map[string]*scheduleEntry
+}
+
+func newScheduler(server *HttpServer) *scheduler {
+	return &scheduler{
+		server:  server,
+		cron:    cron.New(),
+		entries: make(map[string]*scheduleEntry),
+	}
+}
+
+// parseSchedule 解析标准的5段cron表达式,timezone为空时使用本地时区
+func parseSchedule(expr string, timezone string) (cron.Schedule, error) {
+	if len(timezone) > 0 {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return nil, err
+		}
+		expr = "CRON_TZ=" + timezone + " " + expr
+	}
+	return cron.ParseStandard(expr)
+}
+
+func (s *scheduler) start() {
+	records, err := s.server.DB.ReadSchedules()
+	if err != nil {
+		common.LoggerStd.Error("read schedules", zap.Error(err))
+	}
+	for i := range records {
+		if err := s.put(&records[i]); err != nil {
+			common.LoggerStd.Error("schedule "+records[i].Id, zap.Error(err))
+		}
+	}
+	s.cron.Start()
+	common.LoggerStd.Info("scheduler start", zap.Int("schedules", len(records)))
+}
+
+func (s *scheduler) stop() {
+	s.cron.Stop()
+}
+
+// put 新增或者替换定时任务,enabled=false时只移除
+func (s *scheduler) put(record *goja.ScheduleRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if old, ok := s.entries[record.Id]; ok {
+		s.cron.Remove(old.entryId)
+		delete(s.entries, record.Id)
+	}
+	if !record.Enabled {
+		return nil
+	}
+	schedule, err := parseSchedule(record.Cron, record.Timezone)
+	if err != nil {
+		return err
+	}
+	entry := &scheduleEntry{record: *record}
+	entry.entryId = s.cron.Schedule(schedule, cron.FuncJob(func() {
+		s.fire(entry)
+	}))
+	s.entries[record.Id] = entry
+	return nil
+}
+
+func (s *scheduler) remove(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if old, ok := s.entries[id]; ok {
+		s.cron.Remove(old.entryId)
+		delete(s.entries, id)
+	}
+}
+
+// nextTime 下一次触发的时间,没有调度时返回0
+func (s *scheduler) nextTime(id string) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if entry, ok := s.entries[id]; ok {
+		if next := s.cron.Entry(entry.entryId).Next; !next.IsZero() {
+			return next.UnixMilli()
+		}
+	}
+	return 0
+}
+
+func (s *scheduler) fire(entry *scheduleEntry) {
+	r := entry.record
+	r.LastFireTime = time.Now().UnixMilli()
+	if !entry.running.TryLock() {
+		switch r.Overlap {
+		case goja.OverlapReplace:
+			common.LoggerStd.Info("schedule replace", zap.String("id", r.Id), zap.String("taskName", r.TaskName))
+			s.server.stopScript(&TaskRequest{TaskName: r.TaskName})
+			entry.running.Lock()
+		case goja.OverlapQueue:
+			if !entry.waiting.CompareAndSwap(false, true) {
+				s.finish(&r, "", goja.ScheduleStatusSkipped, "a queued firing is already waiting")
+				return
+			}
+			entry.running.Lock()
+			entry.waiting.Store(false)
+		default:
+			s.finish(&r, "", goja.ScheduleStatusSkipped, "previous run not finished")
+			return
+		}
+	}
+	defer entry.running.Unlock()
+	instance, err := s.server.submitScript(&RunScriptRequest{
+		ScriptId:   r.ScriptId,
+		ScriptUri:  r.ScriptUri,
+		TaskName:   r.TaskName,
+		Parameters: r.ParameterMap(),
+		Priority:   queue.PriorityLow,
+	})
+	if err != nil {
+		s.finish(&r, "", goja.ScheduleStatusFailed, err.Message)
+		return
+	}
+	runId := instance.Context.RunId
+	<-instance.Done()
+	if instance.IsSuccess {
+		s.finish(&r, runId, goja.RunStatusSuccess, "")
+	} else {
+		s.finish(&r, runId, goja.RunStatusError, instance.ErrorMessage)
+	}
+}
+
+func (s *scheduler) finish(r *goja.ScheduleRecord, runId string, status string, message string) {
+	r.LastRunId = runId
+	r.LastStatus = status
+	r.LastError = message
+	s.server.DB.FinishSchedule(r)
+	common.LoggerStd.Info("schedule fired", zap.String("id", r.Id), zap.String("taskName", r.TaskName),
+		zap.String("runId", runId), zap.String("status", status), zap.String("error", message))
+}